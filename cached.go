@@ -0,0 +1,66 @@
+package tlsredis
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// CachedURLs returns the URLs of all cached clients in sorted order, in the
+// form scheme://host:port/db and without any credentials. Each URL is listed
+// once, even if several clients are cached for it (e.g. by GetPubSubClient or
+// a CacheKeyFunc).
+func CachedURLs() []string {
+	rcsMutex.Lock()
+	seen := make(map[string]bool, len(rcs))
+	for _, cc := range rcs {
+		seen[cc.url] = true
+	}
+	rcsMutex.Unlock()
+
+	urls := make([]string, 0, len(seen))
+	for u := range seen {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// CloseClient removes all cached clients for redisURL (as listed by
+// CachedURLs, although credentials in redisURL are ignored) from the cache and
+// closes them. It returns the first error encountered while closing.
+func CloseClient(redisURL string) error {
+	u, err := parseURL(redisURL, &Options{})
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, cc := range uncache(endpointURL(u, dbFromPath(u))) {
+		if err := cc.client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// uncache removes all clients for the endpoint URL target from the cache and
+// returns them.
+func uncache(target string) []*cachedClient {
+	rcsMutex.Lock()
+	defer rcsMutex.Unlock()
+	var removed []*cachedClient
+	for key, cc := range rcs {
+		if cc.url == target {
+			removed = append(removed, cc)
+			delete(rcs, key)
+		}
+	}
+	return removed
+}
+
+// endpointURL identifies database db at u without any credentials.
+func endpointURL(u *url.URL, db int) string {
+	return fmt.Sprintf("%v://%v/%d", strings.ToLower(u.Scheme), u.Host, db)
+}
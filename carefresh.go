@@ -0,0 +1,37 @@
+package tlsredis
+
+import (
+	"crypto/tls"
+	"sync"
+	"time"
+)
+
+// refreshingConfig holds a tls.Config that's rebuilt (and with it the Redis CA
+// reloaded) once it's older than interval.
+type refreshingConfig struct {
+	config   *tls.Config
+	loadedAt time.Time
+	interval time.Duration
+	build    func() (*tls.Config, error)
+	mx       sync.Mutex
+}
+
+// get returns the current config, rebuilding it first if it's due. If the
+// rebuild fails, the previous config stays in use until the next interval.
+func (rc *refreshingConfig) get() *tls.Config {
+	rc.mx.Lock()
+	defer rc.mx.Unlock()
+
+	if now().Sub(rc.loadedAt) >= rc.interval {
+		config, err := rc.build()
+		if err != nil {
+			log.Errorf("Unable to reload Redis CA, still using the previous one: %v", err)
+		} else {
+			// Keep resuming sessions established with the previous config
+			config.ClientSessionCache = rc.config.ClientSessionCache
+			rc.config = config
+		}
+		rc.loadedAt = now()
+	}
+	return rc.config
+}
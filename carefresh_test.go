@@ -0,0 +1,86 @@
+package tlsredis
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCARefreshInterval(t *testing.T) {
+	oldCA, newCA := newTestCA(t, "Old CA"), newTestCA(t, "New CA")
+	oldCert, newCert := newServerCert(t, oldCA).tlsCertificate(), newServerCert(t, newCA).tlsCertificate()
+
+	var mx sync.Mutex
+	serverCert, caPEM := &oldCert, oldCA.certPEM()
+	rotate := func() {
+		mx.Lock()
+		serverCert, caPEM = &newCert, newCA.certPEM()
+		mx.Unlock()
+	}
+	srv := startFakeRedis(t, &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			mx.Lock()
+			defer mx.Unlock()
+			return serverCert, nil
+		},
+	})
+	caFile := writeTestFile(t, "ca.pem", oldCA.certPEM())
+	caServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		defer mx.Unlock()
+		w.Write(caPEM)
+	}))
+	defer caServer.Close()
+
+	clock := time.Now()
+	now = func() time.Time {
+		mx.Lock()
+		defer mx.Unlock()
+		return clock
+	}
+	defer func() { now = time.Now }()
+
+	for name, opts := range map[string]*Options{
+		"RedisCAFile": {RedisURL: srv.url(), RedisCAFile: caFile, CARefreshInterval: time.Minute},
+		"RedisCAURL":  {RedisURL: srv.url(), RedisCAURL: caServer.URL, CARefreshInterval: time.Minute},
+	} {
+		mx.Lock()
+		serverCert, caPEM = &oldCert, oldCA.certPEM()
+		mx.Unlock()
+		if err := ioutil.WriteFile(caFile, oldCA.certPEM(), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		dial, err := BuildDialer(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dial()
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		conn.Close()
+
+		rotate()
+		if err := ioutil.WriteFile(caFile, newCA.certPEM(), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dial(); err == nil {
+			t.Errorf("%v: expected the old CA to stay in use until the interval passed", name)
+		}
+
+		mx.Lock()
+		clock = clock.Add(2 * time.Minute)
+		mx.Unlock()
+		conn, err = dial()
+		if err != nil {
+			t.Errorf("%v: expected new dials to trust the new CA: %v", name, err)
+		} else {
+			conn.Close()
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package tlsredis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"gopkg.in/redis.v5"
+)
+
+// ClientSet holds clients for a number of named Redis endpoints (e.g. "cache",
+// "queue"). Its clients are created and closed independently of the cache used
+// by GetClient. The zero value is an empty ClientSet ready for use, and a
+// ClientSet is safe for concurrent use.
+type ClientSet struct {
+	clients map[string]*redis.Client
+	mx      sync.Mutex
+}
+
+// Add creates a new client using opts and registers it under name.
+func (cs *ClientSet) Add(name string, opts *Options) error {
+	cs.mx.Lock()
+	_, exists := cs.clients[name]
+	cs.mx.Unlock()
+	if exists {
+		return fmt.Errorf("Client %v already exists", name)
+	}
+
+	// Building the client may involve reading files and fetching CAs, so don't
+	// hold up the other clients in the set while doing so.
+	rc, err := newUncachedClient(context.Background(), opts)
+	if err != nil {
+		return fmt.Errorf("Unable to create client %v: %w", name, err)
+	}
+
+	cs.mx.Lock()
+	defer cs.mx.Unlock()
+	if _, exists := cs.clients[name]; exists {
+		// Lost a race with a concurrent Add of the same name
+		rc.Close()
+		return fmt.Errorf("Client %v already exists", name)
+	}
+	if cs.clients == nil {
+		cs.clients = make(map[string]*redis.Client)
+	}
+	cs.clients[name] = rc
+	return nil
+}
+
+// Get returns the client registered under name.
+func (cs *ClientSet) Get(name string) (*redis.Client, error) {
+	cs.mx.Lock()
+	defer cs.mx.Unlock()
+
+	rc, ok := cs.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown client %v", name)
+	}
+	return rc, nil
+}
+
+// CloseAll closes all clients in the set and removes them from it, returning
+// the first error encountered, if any.
+func (cs *ClientSet) CloseAll() error {
+	cs.mx.Lock()
+	defer cs.mx.Unlock()
+
+	var firstErr error
+	for name, rc := range cs.clients {
+		if err := rc.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("Unable to close client %v: %w", name, err)
+		}
+		delete(cs.clients, name)
+	}
+	return firstErr
+}
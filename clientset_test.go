@@ -0,0 +1,92 @@
+package tlsredis
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestClientSet(t *testing.T) {
+	cache, queue := startFakeRedis(t, nil), startFakeRedis(t, nil)
+	var cs ClientSet
+	defer cs.CloseAll()
+	if err := cs.Add("cache", &Options{RedisURL: cache.url()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.Add("queue", &Options{RedisURL: queue.url()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.Add("cache", &Options{RedisURL: queue.url()}); err == nil {
+		t.Error("Expected an error adding a duplicate name")
+	}
+
+	for name, srv := range map[string]*fakeRedis{"cache": cache, "queue": queue} {
+		rc, err := cs.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rc.Set(name, "value", 0).Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !srv.received("SET", name, "value") {
+			t.Errorf("Expected client %v to talk to its own server", name)
+		}
+		for _, cached := range CachedURLs() {
+			if strings.Contains(cached, srv.addr) {
+				t.Errorf("Expected client %v not to be in the global cache", name)
+			}
+		}
+	}
+	if _, err := cs.Get("ratelimit"); err == nil {
+		t.Error("Expected an error getting an unknown name")
+	}
+
+	rc, _ := cs.Get("cache")
+	if err := cs.CloseAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err == nil {
+		t.Error("Expected CloseAll to close the clients")
+	}
+	if _, err := cs.Get("cache"); err == nil {
+		t.Error("Expected CloseAll to remove the clients")
+	}
+}
+
+func TestClientSetConcurrentAdd(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	var cs ClientSet
+	defer cs.CloseAll()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- cs.Add("cache", &Options{RedisURL: srv.url()})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	added := 0
+	for err := range errs {
+		if err == nil {
+			added++
+		}
+	}
+	if added != 1 {
+		t.Errorf("Expected exactly one Add to succeed, got %v", added)
+	}
+	if _, err := cs.Get("cache"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClientSetNilOptions(t *testing.T) {
+	var cs ClientSet
+	if err := cs.Add("cache", nil); !errors.Is(err, ErrNilOptions) {
+		t.Errorf("Expected an error wrapping ErrNilOptions, got %v", err)
+	}
+}
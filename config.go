@@ -0,0 +1,251 @@
+package tlsredis
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationType  = reflect.TypeOf(time.Duration(0))
+	tlsConfigType = reflect.TypeOf(&tls.Config{})
+
+	// durationFields are the lowercased names of all time.Duration fields in
+	// Options, including the ones promoted from redis.Options.
+	durationFields = findDurationFields(reflect.TypeOf(Options{}), make(map[string]bool))
+
+	// optionSetters parse textual configuration values into Options, keyed by
+	// the names used by OptionsFromEnv.
+	optionSetters = map[string]func(opts *Options, value string) error{
+		"URL":                   stringSetter(func(opts *Options) *string { return &opts.RedisURL }),
+		"CA_FILE":               stringSetter(func(opts *Options) *string { return &opts.RedisCAFile }),
+		"CA_URL":                stringSetter(func(opts *Options) *string { return &opts.RedisCAURL }),
+		"CA_DIR":                stringSetter(func(opts *Options) *string { return &opts.RedisCADir }),
+		"CLIENT_CERT_FILE":      stringSetter(func(opts *Options) *string { return &opts.ClientCertFile }),
+		"CLIENT_KEY_FILE":       stringSetter(func(opts *Options) *string { return &opts.ClientPKFile }),
+		"PASSWORD":              stringSetter(func(opts *Options) *string { return &opts.Password }),
+		"PASSWORD_FILE":         stringSetter(func(opts *Options) *string { return &opts.PasswordFile }),
+		"DIAL_TIMEOUT":          durationSetter(func(opts *Options) *time.Duration { return &opts.DialTimeout }),
+		"READ_TIMEOUT":          durationSetter(func(opts *Options) *time.Duration { return &opts.ReadTimeout }),
+		"WRITE_TIMEOUT":         durationSetter(func(opts *Options) *time.Duration { return &opts.WriteTimeout }),
+		"TCP_KEEPALIVE":         durationSetter(func(opts *Options) *time.Duration { return &opts.TCPKeepAlive }),
+		"DISABLE_TCP_KEEPALIVE": boolSetter(func(opts *Options) *bool { return &opts.DisableTCPKeepAlive }),
+		"POOL_SIZE":             intSetter(func(opts *Options) *int { return &opts.PoolSize }),
+		"MAX_RETRIES":           intSetter(func(opts *Options) *int { return &opts.MaxRetries }),
+		"INSECURE_SKIP_VERIFY":  boolSetter(func(opts *Options) *bool { return &opts.InsecureSkipVerify }),
+		"FIPS_MODE":             boolSetter(func(opts *Options) *bool { return &opts.FIPSMode }),
+		"WATCH_CERT_FILES":      boolSetter(func(opts *Options) *bool { return &opts.WatchCertFiles }),
+		"FALLBACK_TO_PLAINTEXT": boolSetter(func(opts *Options) *bool { return &opts.FallbackToPlaintext }),
+	}
+)
+
+// OptionsFromEnv builds Options from environment variables named
+// <prefix>_<NAME>, where NAME is one of URL, CA_FILE, CA_URL, CA_DIR,
+// CLIENT_CERT_FILE, CLIENT_KEY_FILE, PASSWORD, PASSWORD_FILE, DIAL_TIMEOUT,
+// READ_TIMEOUT, WRITE_TIMEOUT, TCP_KEEPALIVE, DISABLE_TCP_KEEPALIVE,
+// POOL_SIZE, MAX_RETRIES, INSECURE_SKIP_VERIFY, FIPS_MODE, WATCH_CERT_FILES or
+// FALLBACK_TO_PLAINTEXT. Durations are parsed with time.ParseDuration and
+// bools with strconv.ParseBool. Unset variables leave the corresponding option
+// at its zero value. If any values are malformed, the returned error lists all
+// of them.
+func OptionsFromEnv(prefix string) (*Options, error) {
+	opts := &Options{}
+	var errs []string
+	for _, name := range optionNames() {
+		envName := name
+		if prefix != "" {
+			envName = prefix + "_" + name
+		}
+		value, ok := os.LookupEnv(envName)
+		if !ok {
+			continue
+		}
+		if err := optionSetters[name](opts, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", envName, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("Invalid Redis configuration: %v", strings.Join(errs, "; "))
+	}
+	return opts, nil
+}
+
+// OptionsFromMap builds Options from a flat map of configuration values, such
+// as one obtained from a key/value store. Keys are the same names that
+// OptionsFromEnv uses (without a prefix) but are matched case-insensitively.
+// Unknown keys are treated as errors in order to catch typos. If any keys are
+// unknown or any values are malformed, the returned error lists all of them.
+func OptionsFromMap(m map[string]string) (*Options, error) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	opts := &Options{}
+	var errs []string
+	for _, key := range keys {
+		setter, ok := optionSetters[strings.ToUpper(key)]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%v: unknown option", key))
+			continue
+		}
+		if err := setter(opts, m[key]); err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("Invalid Redis configuration: %v", strings.Join(errs, "; "))
+	}
+	return opts, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It behaves just like the default
+// decoding except that duration fields (e.g. DialTimeout) accept duration
+// strings like "5s" in addition to numbers of nanoseconds.
+func (opts *Options) UnmarshalJSON(b []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+
+	for name, raw := range fields {
+		if !durationFields[strings.ToLower(name)] {
+			continue
+		}
+		var str string
+		if json.Unmarshal(raw, &str) != nil {
+			// Not a string, leave it to the default decoding
+			continue
+		}
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return fmt.Errorf("Invalid duration for %v: %v", name, err)
+		}
+		fields[name], _ = json.Marshal(int64(d))
+	}
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	// plainOptions doesn't have our methods, so this uses the default decoding
+	type plainOptions Options
+	return json.Unmarshal(b, (*plainOptions)(opts))
+}
+
+// MarshalJSON implements json.Marshaler. Duration fields are encoded as
+// duration strings like "5s", and fields that can't meaningfully be encoded
+// (functions, interfaces and the redis TLSConfig) are omitted.
+func (opts Options) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]interface{})
+	flattenFields(reflect.ValueOf(opts), fields)
+	return json.Marshal(fields)
+}
+
+func findDurationFields(t reflect.Type, names map[string]bool) map[string]bool {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			findDurationFields(field.Type, names)
+		} else if field.Type == durationType {
+			names[strings.ToLower(field.Name)] = true
+		}
+	}
+	return names
+}
+
+// flattenFields adds the encodable exported fields of the struct v to fields,
+// including those of embedded structs. Fields that are already present (i.e.
+// shadowed by a shallower field of the same name) are left alone.
+func flattenFields(v reflect.Value, fields map[string]interface{}) {
+	t := v.Type()
+	var embedded []reflect.Value
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, v.Field(i))
+			continue
+		}
+		switch field.Type.Kind() {
+		case reflect.Func, reflect.Chan, reflect.Interface:
+			continue
+		}
+		if field.Type == tlsConfigType {
+			continue
+		}
+		if field.Type == durationType {
+			fields[field.Name] = time.Duration(v.Field(i).Int()).String()
+		} else {
+			fields[field.Name] = v.Field(i).Interface()
+		}
+	}
+	for _, e := range embedded {
+		sub := make(map[string]interface{})
+		flattenFields(e, sub)
+		for name, value := range sub {
+			if _, shadowed := fields[name]; !shadowed {
+				fields[name] = value
+			}
+		}
+	}
+}
+
+// optionNames returns the names of all optionSetters in sorted order.
+func optionNames() []string {
+	names := make([]string, 0, len(optionSetters))
+	for name := range optionSetters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func stringSetter(field func(*Options) *string) func(*Options, string) error {
+	return func(opts *Options, value string) error {
+		*field(opts) = value
+		return nil
+	}
+}
+
+func durationSetter(field func(*Options) *time.Duration) func(*Options, string) error {
+	return func(opts *Options, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(opts) = d
+		return nil
+	}
+}
+
+func intSetter(field func(*Options) *int) func(*Options, string) error {
+	return func(opts *Options, value string) error {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(opts) = i
+		return nil
+	}
+}
+
+func boolSetter(field func(*Options) *bool) func(*Options, string) error {
+	return func(opts *Options, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(opts) = b
+		return nil
+	}
+}
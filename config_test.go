@@ -0,0 +1,114 @@
+package tlsredis
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptionsJSON(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+
+	doc := `{
+		"RedisURL": "` + srv.url() + `",
+		"DialTimeout": "5s",
+		"ReadTimeout": 2000000000,
+		"TCPKeepAlive": "1m30s",
+		"PoolSize": 7
+	}`
+	opts := &Options{}
+	if err := json.Unmarshal([]byte(doc), opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.RedisURL != srv.url() || opts.PoolSize != 7 {
+		t.Errorf("Unexpected options %+v", opts)
+	}
+	if opts.DialTimeout != 5*time.Second || opts.ReadTimeout != 2*time.Second || opts.TCPKeepAlive != 90*time.Second {
+		t.Errorf("Unexpected durations %v, %v and %v", opts.DialTimeout, opts.ReadTimeout, opts.TCPKeepAlive)
+	}
+
+	rc, err := GetClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"DialTimeout":"5s"`) {
+		t.Errorf("Expected durations to be encoded as strings, got %v", string(b))
+	}
+	roundTripped := &Options{}
+	if err := json.Unmarshal(b, roundTripped); err != nil {
+		t.Fatal(err)
+	}
+	if roundTripped.DialTimeout != opts.DialTimeout || roundTripped.PoolSize != opts.PoolSize || roundTripped.RedisURL != opts.RedisURL {
+		t.Errorf("Options didn't survive a round trip: %+v", roundTripped)
+	}
+
+	err = json.Unmarshal([]byte(`{"DialTimeout": "soon"}`), &Options{})
+	if err == nil || !strings.Contains(err.Error(), "DialTimeout") {
+		t.Errorf("Expected an error for a malformed duration, got %v", err)
+	}
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv("TEST_REDIS_URL", "rediss://redis.example.com:6380")
+	t.Setenv("TEST_REDIS_CA_FILE", "/etc/redis/ca.pem")
+	t.Setenv("TEST_REDIS_DIAL_TIMEOUT", "3s")
+	t.Setenv("TEST_REDIS_POOL_SIZE", "12")
+	t.Setenv("TEST_REDIS_INSECURE_SKIP_VERIFY", "true")
+
+	opts, err := OptionsFromEnv("TEST_REDIS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.RedisURL != "rediss://redis.example.com:6380" || opts.RedisCAFile != "/etc/redis/ca.pem" {
+		t.Errorf("Unexpected strings in %+v", opts)
+	}
+	if opts.DialTimeout != 3*time.Second || opts.PoolSize != 12 || !opts.InsecureSkipVerify {
+		t.Errorf("Unexpected values in %+v", opts)
+	}
+	if opts.ClientCertFile != "" || opts.ReadTimeout != 0 {
+		t.Errorf("Expected unset variables to leave options at their zero value")
+	}
+
+	t.Setenv("TEST_REDIS_DIAL_TIMEOUT", "soon")
+	t.Setenv("TEST_REDIS_POOL_SIZE", "many")
+	_, err = OptionsFromEnv("TEST_REDIS")
+	if err == nil || !strings.Contains(err.Error(), "TEST_REDIS_DIAL_TIMEOUT") || !strings.Contains(err.Error(), "TEST_REDIS_POOL_SIZE") {
+		t.Errorf("Expected an error listing both malformed values, got %v", err)
+	}
+}
+
+func TestOptionsFromMap(t *testing.T) {
+	opts, err := OptionsFromMap(map[string]string{
+		"url":                  "rediss://redis.example.com:6380",
+		"Client_Cert_File":     "/etc/redis/client.pem",
+		"dial_timeout":         "250ms",
+		"INSECURE_SKIP_VERIFY": "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.RedisURL != "rediss://redis.example.com:6380" || opts.ClientCertFile != "/etc/redis/client.pem" ||
+		opts.DialTimeout != 250*time.Millisecond || !opts.InsecureSkipVerify {
+		t.Errorf("Unexpected options %+v", opts)
+	}
+
+	_, err = OptionsFromMap(map[string]string{"url": "redis://localhost", "dial_timout": "1s"})
+	if err == nil || !strings.Contains(err.Error(), "dial_timout: unknown option") {
+		t.Errorf("Expected an error for the unknown key, got %v", err)
+	}
+
+	_, err = OptionsFromMap(map[string]string{"dial_timeout": "10"})
+	if err == nil || !strings.Contains(err.Error(), "dial_timeout") {
+		t.Errorf("Expected an error for the malformed duration, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package tlsredis
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// defaultConfigBuilder memoizes the TLS configurations and dialers of all
+// clients.
+var defaultConfigBuilder = NewConfigBuilder()
+
+// maxMemoizedConfigs is how many TLS configurations a ConfigBuilder keeps
+// before discarding the least recently used one.
+var maxMemoizedConfigs = 256
+
+// ConfigBuilder builds TLS configurations like GetClient does and memoizes
+// them, so that building many clients with identical options loads and parses
+// their CAs and client certificates only once. Each caller still gets a copy
+// of its own, with a TLS session cache of its own unless ShareSessionCache is
+// set. GetClient and friends already use a ConfigBuilder of their own, which
+// also memoizes their net.Dialers; this is for callers that need the
+// configurations themselves. A ConfigBuilder is safe for concurrent use.
+//
+// Options with a TLSConfigHook, ClientCertificates or TLSSessionCache can't be
+// compared, and the contents of a RedisCADir may change at any time, so
+// configurations for those are built afresh every time. Other configurations
+// are rebuilt whenever the RedisCAFile, ClientCertFile or ClientPKFile in use
+// is modified. Up to 256 configurations are kept, after which the least
+// recently used one is discarded.
+type ConfigBuilder struct {
+	configs map[string]*memoizedConfig
+	dialers map[string]*net.Dialer
+	uses    uint64
+	mx      sync.Mutex
+}
+
+type memoizedConfig struct {
+	mtimes   string
+	config   *tls.Config
+	lastUsed uint64
+}
+
+// NewConfigBuilder creates a new, empty ConfigBuilder.
+func NewConfigBuilder() *ConfigBuilder {
+	return &ConfigBuilder{
+		configs: make(map[string]*memoizedConfig),
+		dialers: make(map[string]*net.Dialer),
+	}
+}
+
+// TLSConfig returns the TLS configuration for connecting to the rediss URL in
+// opts. The configuration is the caller's own copy, which it's free to modify.
+func (b *ConfigBuilder) TLSConfig(opts *Options) (*tls.Config, error) {
+	if opts == nil {
+		return nil, ErrNilOptions
+	}
+	u, err := parseURL(opts.RedisURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	timeout := opts.DialTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	return b.get(opts, u, timeout)
+}
+
+// get returns a copy of the memoized TLS configuration for opts and u, building
+// it if necessary.
+func (b *ConfigBuilder) get(opts *Options, u *url.URL, timeout time.Duration) (*tls.Config, error) {
+	if opts.TLSConfigHook != nil || len(opts.ClientCertificates) > 0 || opts.RedisCADir != "" || opts.TLSSessionCache != nil {
+		return buildTLSConfig(opts, u, timeout)
+	}
+	key := tlsConfigKey(opts, u)
+	mtimes := fmt.Sprint(certMTimes(opts))
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.uses++
+	if memoized := b.configs[key]; memoized != nil && memoized.mtimes == mtimes {
+		log.Debugf("Reusing TLS configuration for %v", u.Host)
+		memoized.lastUsed = b.uses
+		return forCaller(memoized.config, opts), nil
+	}
+	config, err := buildTLSConfig(opts, u, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if _, found := b.configs[key]; !found && len(b.configs) >= maxMemoizedConfigs {
+		b.evictLeastRecentlyUsed()
+	}
+	b.configs[key] = &memoizedConfig{mtimes: mtimes, config: config, lastUsed: b.uses}
+	return forCaller(config, opts), nil
+}
+
+// evictLeastRecentlyUsed discards the least recently used configuration. b.mx
+// must be held.
+func (b *ConfigBuilder) evictLeastRecentlyUsed() {
+	var oldestKey string
+	var oldest *memoizedConfig
+	for key, memoized := range b.configs {
+		if oldest == nil || memoized.lastUsed < oldest.lastUsed {
+			oldestKey, oldest = key, memoized
+		}
+	}
+	delete(b.configs, oldestKey)
+}
+
+// forCaller returns a copy of the memoized config for a single caller, which
+// only shares the TLS session cache if ShareSessionCache is set.
+func forCaller(config *tls.Config, opts *Options) *tls.Config {
+	config = config.Clone()
+	if config.ClientSessionCache != nil && !opts.ShareSessionCache {
+		config.ClientSessionCache = tls.NewLRUClientSessionCache(1000)
+	}
+	return config
+}
+
+// dialer returns the memoized net.Dialer for opts, building it if necessary.
+// The result is shared and must not be modified.
+func (b *ConfigBuilder) dialer(opts *Options) *net.Dialer {
+	key := fmt.Sprintf("%#v", []interface{}{opts.DialTimeout, opts.TCPKeepAlive, opts.DisableTCPKeepAlive, opts.ConnectDeadline})
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if dialer := b.dialers[key]; dialer != nil {
+		return dialer
+	}
+	dialer := newNetDialer(opts)
+	b.dialers[key] = dialer
+	return dialer
+}
+
+// tlsConfigKey identifies the options that buildTLSConfig uses to build the
+// configuration for u.
+func tlsConfigKey(opts *Options, u *url.URL) string {
+	caFile, certFile, pkFile := opts.credentialFiles()
+	return fmt.Sprintf("%#v", []interface{}{
+		u.Host,
+		caFile, certFile, pkFile,
+		opts.RedisCAURL,
+		opts.DisableSessionResumption, opts.ShareSessionCache,
+		opts.InsecureSkipVerify, opts.FIPSMode, opts.CurvePreferences, opts.Renegotiation,
+		opts.AllowedServerNames, opts.VerifyServerName, opts.VerifyViaReverseDNS, opts.VerifyPeerIP,
+		opts.RequireServerAuthEKU, opts.PinnedSerials, opts.PinnedSubjects, opts.AllowedSignatureAlgorithms,
+	})
+}
@@ -0,0 +1,209 @@
+package tlsredis
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConfigBuilder(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM())
+	client := newClientCert(t, ca)
+	certFile := writeTestFile(t, "client.pem", client.certPEM())
+	keyFile := writeTestFile(t, "client.key", client.keyPEM())
+	newOpts := func() *Options {
+		return &Options{RedisURL: "rediss://127.0.0.1:6380", RedisCAFile: caFile, ClientCertFile: certFile, ClientPKFile: keyFile}
+	}
+	builder := NewConfigBuilder()
+	get := func(opts *Options) *tls.Config {
+		t.Helper()
+		u, err := parseURL(opts.RedisURL, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := builder.get(opts, u, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config
+	}
+
+	// Copies of the same configuration share the CAs parsed for it
+	config := get(newOpts())
+	if get(newOpts()).RootCAs != config.RootCAs {
+		t.Error("Expected identical options to reuse the memoized configuration")
+	}
+	fips := newOpts()
+	fips.FIPSMode = true
+	if get(fips).RootCAs == config.RootCAs {
+		t.Error("Expected different options to get a configuration of their own")
+	}
+	otherHost := newOpts()
+	otherHost.RedisURL = "rediss://127.0.0.1:6381"
+	if get(otherHost).RootCAs == config.RootCAs {
+		t.Error("Expected a different host to get a configuration of its own")
+	}
+	hooked := newOpts()
+	hooked.TLSConfigHook = func(*tls.Config) {}
+	if get(hooked).RootCAs == get(hooked).RootCAs {
+		t.Error("Expected configurations with a TLSConfigHook to be built every time")
+	}
+
+	public, err := builder.TLSConfig(newOpts())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public == config || public.RootCAs != config.RootCAs {
+		t.Error("Expected TLSConfig to return a copy of the memoized configuration")
+	}
+
+	// Touching the client key rebuilds the configuration
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(keyFile, future, future); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt := get(newOpts())
+	if rebuilt.RootCAs == config.RootCAs {
+		t.Error("Expected a modified credential file to rebuild the configuration")
+	}
+	if get(newOpts()).RootCAs != rebuilt.RootCAs {
+		t.Error("Expected the rebuilt configuration to be memoized")
+	}
+}
+
+func TestConfigBuilderSessionCaches(t *testing.T) {
+	caFile := writeTestFile(t, "ca.pem", newTestCA(t, "Test CA").certPEM())
+	builder := NewConfigBuilder()
+	for _, share := range []bool{false, true} {
+		opts := &Options{RedisURL: "rediss://127.0.0.1:6380", RedisCAFile: caFile, ShareSessionCache: share}
+		first, err := builder.TLSConfig(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := builder.TLSConfig(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if shared := first.ClientSessionCache == second.ClientSessionCache; shared != share {
+			t.Errorf("ShareSessionCache %v: expected sharing the session cache to be %v", share, share)
+		}
+	}
+}
+
+func TestConfigBuilderEviction(t *testing.T) {
+	caFile := writeTestFile(t, "ca.pem", newTestCA(t, "Test CA").certPEM())
+	defer func(max int) { maxMemoizedConfigs = max }(maxMemoizedConfigs)
+	maxMemoizedConfigs = 2
+	builder := NewConfigBuilder()
+	get := func(port int) *tls.Config {
+		t.Helper()
+		config, err := builder.TLSConfig(&Options{RedisURL: fmt.Sprintf("rediss://127.0.0.1:%d", port), RedisCAFile: caFile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config
+	}
+
+	first := get(6380)
+	get(6381)
+	get(6380)
+	get(6382)
+	if len(builder.configs) != 2 {
+		t.Errorf("Expected 2 memoized configurations, got %d", len(builder.configs))
+	}
+	if get(6380).RootCAs != first.RootCAs {
+		t.Error("Expected the recently used configuration to be kept")
+	}
+}
+
+func TestConfigBuilderClock(t *testing.T) {
+	caFile := writeTestFile(t, "ca.pem", newTestCA(t, "Test CA").certPEM())
+	config, err := NewConfigBuilder().TLSConfig(&Options{RedisURL: "rediss://127.0.0.1:6380", RedisCAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The configuration follows the clock rather than the one at build time
+	later := time.Now().Add(time.Hour)
+	now = func() time.Time { return later }
+	defer func() { now = time.Now }()
+	if !config.Time().Equal(later) {
+		t.Errorf("Expected the configuration to use the current clock, got %v", config.Time())
+	}
+}
+
+func TestConfigBuilderDialers(t *testing.T) {
+	builder := NewConfigBuilder()
+	opts := &Options{DialTimeout: time.Second}
+	if builder.dialer(opts) != builder.dialer(&Options{DialTimeout: time.Second}) {
+		t.Error("Expected identical options to share a dialer")
+	}
+	if builder.dialer(opts) == builder.dialer(&Options{DialTimeout: 2 * time.Second}) {
+		t.Error("Expected a different DialTimeout to get a dialer of its own")
+	}
+}
+
+func TestConfigBuilderSharedAcrossDialers(t *testing.T) {
+	srv, caFile := startTLSFakeRedis(t)
+	opts := &Options{RedisURL: srv.url(), RedisCAFile: caFile}
+	u, err := parseURL(opts.RedisURL, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := tlsConfigKey(opts, u)
+	memoized := func() *tls.Config {
+		defaultConfigBuilder.mx.Lock()
+		defer defaultConfigBuilder.mx.Unlock()
+		if defaultConfigBuilder.configs[key] == nil {
+			return nil
+		}
+		return defaultConfigBuilder.configs[key].config
+	}
+
+	var configs []*tls.Config
+	for i := 0; i < 2; i++ {
+		dial, err := BuildDialer(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pingConn(t, conn)
+		conn.Close()
+		configs = append(configs, memoized())
+	}
+	if configs[0] == nil || configs[0] != configs[1] {
+		t.Error("Expected dialers with identical options to share one TLS configuration")
+	}
+}
+
+func BenchmarkConfigBuilder(b *testing.B) {
+	caFile := writeTestFile(b, "ca.pem", newTestCA(b, "Test CA").certPEM())
+	opts := &Options{RedisURL: "rediss://127.0.0.1:6380", RedisCAFile: caFile}
+	u, err := parseURL(opts.RedisURL, opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("memoized", func(b *testing.B) {
+		builder := NewConfigBuilder()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := builder.get(opts, u, time.Second); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unmemoized", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := buildTLSConfig(opts, u, time.Second); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
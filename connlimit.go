@@ -0,0 +1,68 @@
+package tlsredis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// hostSlots holds a semaphore for each host with a MaxConnsPerHost, keyed
+	// by host and limit.
+	hostSlots      = make(map[string]chan struct{})
+	hostSlotsMutex sync.Mutex
+)
+
+// hostSemaphore returns the semaphore limiting connections to host to max.
+func hostSemaphore(host string, max int) chan struct{} {
+	key := fmt.Sprintf("%v/%d", host, max)
+	hostSlotsMutex.Lock()
+	defer hostSlotsMutex.Unlock()
+	slots, found := hostSlots[key]
+	if !found {
+		slots = make(chan struct{}, max)
+		hostSlots[key] = slots
+	}
+	return slots
+}
+
+// withConnLimit wraps connect so that no more than cap(slots) connections made
+// through it are open at the same time. Once that many are open, it waits
+// until one is closed, giving up after timeout or once ctx is done.
+func withConnLimit(ctx context.Context, connect func() (net.Conn, error), slots chan struct{}, timeout time.Duration) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		timer := time.NewTimer(timeout)
+		select {
+		case slots <- struct{}{}:
+			timer.Stop()
+		case <-timer.C:
+			return nil, fmt.Errorf("Timed out after %v waiting for one of %d connections to close", timeout, cap(slots))
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		release := func() { <-slots }
+		conn, err := connect()
+		if err != nil {
+			release()
+			return nil, err
+		}
+		return &limitedConn{Conn: conn, release: release}, nil
+	}
+}
+
+// limitedConn is a net.Conn that frees its slot in the semaphore used by
+// withConnLimit when closed.
+type limitedConn struct {
+	net.Conn
+	release   func()
+	closeOnce sync.Once
+}
+
+func (conn *limitedConn) Close() error {
+	err := conn.Conn.Close()
+	conn.closeOnce.Do(conn.release)
+	return err
+}
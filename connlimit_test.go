@@ -0,0 +1,68 @@
+package tlsredis
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxConnsPerHost(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	dial, err := BuildDialer(&Options{RedisURL: srv.url(), MaxConnsPerHost: 2, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+
+	dialed := make(chan net.Conn)
+	go func() {
+		conn, err := dial()
+		if err != nil {
+			t.Error(err)
+		}
+		dialed <- conn
+	}()
+	select {
+	case <-dialed:
+		t.Fatal("Expected the third dial to block while two connections are open")
+	case <-time.After(100 * time.Millisecond):
+	}
+	conns[0].Close()
+	// Closing twice mustn't free up another slot
+	conns[0].Close()
+	select {
+	case conn := <-dialed:
+		if conn != nil {
+			pingConn(t, conn)
+			conns[0] = conn
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the third dial to proceed once a connection was closed")
+	}
+
+	// The limit is shared with other dialers for the same host, and they give
+	// up after DialTimeout
+	other, err := BuildDialer(&Options{RedisURL: srv.url(), MaxConnsPerHost: 2, DialTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other(); err == nil || !strings.Contains(err.Error(), "waiting for one of 2 connections to close") {
+		t.Errorf("Expected waiting for a slot to time out, got %v", err)
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+	conn, err := other()
+	if err != nil {
+		t.Fatalf("Expected slots to be freed once connections are closed: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,65 @@
+package tlsredis
+
+import (
+	"crypto/tls"
+	"sync"
+)
+
+var (
+	connStates      = make(map[string]tls.ConnectionState)
+	connStatesMutex sync.Mutex
+)
+
+// LastConnectionState returns the TLS connection state of the most recent
+// successful rediss connection to the host of redisURL, for example in order to
+// log the negotiated version, cipher suite and server certificates. It returns
+// false if no TLS connection to that host has been made yet. If redisURL has no
+// port, the default rediss port is assumed.
+func LastConnectionState(redisURL string) (*tls.ConnectionState, bool) {
+	u, err := parseURL(redisURL, &Options{})
+	if err != nil {
+		return nil, false
+	}
+	connStatesMutex.Lock()
+	state, found := connStates[u.Host]
+	connStatesMutex.Unlock()
+	if !found {
+		return nil, false
+	}
+	return &state, true
+}
+
+func recordConnectionState(host string, state tls.ConnectionState) {
+	connStatesMutex.Lock()
+	connStates[host] = state
+	connStatesMutex.Unlock()
+}
+
+// connStateHolder holds the TLS connection state of the most recent connection
+// made by a single client, for Snapshot and GetClientInfo. A nil
+// connStateHolder ignores states.
+type connStateHolder struct {
+	mx    sync.Mutex
+	state *tls.ConnectionState
+}
+
+func (holder *connStateHolder) set(state tls.ConnectionState) {
+	if holder == nil {
+		return
+	}
+	holder.mx.Lock()
+	holder.state = &state
+	holder.mx.Unlock()
+}
+
+func (holder *connStateHolder) get() (tls.ConnectionState, bool) {
+	if holder == nil {
+		return tls.ConnectionState{}, false
+	}
+	holder.mx.Lock()
+	defer holder.mx.Unlock()
+	if holder.state == nil {
+		return tls.ConnectionState{}, false
+	}
+	return *holder.state, true
+}
@@ -0,0 +1,35 @@
+package tlsredis
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestLastConnectionState(t *testing.T) {
+	srv, caFile := startTLSFakeRedis(t)
+	if _, found := LastConnectionState(srv.url()); found {
+		t.Fatal("Expected no connection state before connecting")
+	}
+	closeClientOnCleanup(t, srv.url())
+	rc, err := GetClient(&Options{RedisURL: srv.url(), RedisCAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	state, found := LastConnectionState(srv.url() + "/3")
+	if !found {
+		t.Fatal("Expected a connection state after connecting")
+	}
+	if state.Version < tls.VersionTLS12 || state.CipherSuite == 0 || !state.HandshakeComplete {
+		t.Errorf("Unexpected connection state %+v", state)
+	}
+	if len(state.PeerCertificates) == 0 || state.PeerCertificates[0].Subject.CommonName != "redis" {
+		t.Error("Expected the server's certificate in the connection state")
+	}
+	if _, found := LastConnectionState("rediss://localhost:1"); found {
+		t.Error("Expected no connection state for another host")
+	}
+}
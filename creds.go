@@ -0,0 +1,135 @@
+package tlsredis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// CredReport describes the credential files configured in Options, as
+// checked by ValidateCredentials. Files that aren't configured are nil.
+type CredReport struct {
+	CA         *FileReport
+	ClientCert *FileReport
+	ClientKey  *FileReport
+}
+
+// FileReport describes a single credential file.
+type FileReport struct {
+	// Path is the file's path after applying ExpandPaths and CredsDir.
+	Path string
+
+	// Valid indicates whether the file could be loaded, and for certificates,
+	// whether all of them are currently within their validity period. If not,
+	// Err says why.
+	Valid bool
+	Err   error
+
+	// Subject, Issuer and Expiry describe the first certificate in the file
+	// (the leaf for ClientCert). They're empty for ClientKey.
+	Subject string
+	Issuer  string
+	Expiry  time.Time
+}
+
+// ValidateCredentials loads RedisCAFile, ClientCertFile and ClientPKFile as
+// configured in opts and reports on each of them without connecting to Redis,
+// e.g. for pre-flight checks. The client key is valid if it can be parsed and
+// matches the client certificate. The returned error summarizes any problems,
+// in which case the report is still returned.
+func ValidateCredentials(opts *Options) (*CredReport, error) {
+	if opts == nil {
+		return nil, ErrNilOptions
+	}
+	caFile, certFile, pkFile := opts.credentialFiles()
+	if (certFile == "") != (pkFile == "") {
+		return nil, errors.New("ClientCertFile and ClientPKFile must be given together")
+	}
+
+	report := &CredReport{}
+	if caFile != "" {
+		report.CA, _ = certFileReport(caFile, now())
+	}
+	if certFile != "" {
+		var certPEM []byte
+		report.ClientCert, certPEM = certFileReport(certFile, now())
+		report.ClientKey = &FileReport{Path: pkFile}
+		if pkPEM, err := ioutil.ReadFile(pkFile); err != nil {
+			report.ClientKey.Err = err
+		} else if certPEM == nil {
+			report.ClientKey.Err = errors.New("Unable to check key without a usable ClientCertFile")
+		} else if _, err := tls.X509KeyPair(certPEM, pkPEM); err != nil {
+			if _, fallbackErr := keyPairFromPEM(certPEM, pkPEM); fallbackErr != nil {
+				report.ClientKey.Err = fmt.Errorf("%v (%v)", err, fallbackErr)
+			}
+		}
+		report.ClientKey.Valid = report.ClientKey.Err == nil
+	}
+
+	var problems []string
+	for _, file := range []*FileReport{report.CA, report.ClientCert, report.ClientKey} {
+		if file != nil && !file.Valid {
+			problems = append(problems, fmt.Sprintf("%v: %v", file.Path, file.Err))
+		}
+	}
+	if len(problems) > 0 {
+		return report, fmt.Errorf("Invalid credentials: %v", strings.Join(problems, "; "))
+	}
+	return report, nil
+}
+
+// certFileReport reports on the PEM-encoded certificates in file as of now. It
+// also returns the file's contents if they could be parsed.
+func certFileReport(file string, now time.Time) (*FileReport, []byte) {
+	report := &FileReport{Path: file}
+	pemBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		report.Err = err
+		return report, nil
+	}
+
+	var certs []*x509.Certificate
+	rest := pemBytes
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			report.Err = fmt.Errorf("Unable to parse certificate: %v", err)
+			return report, nil
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		report.Err = errors.New("No certificates found")
+		return report, nil
+	}
+
+	report.Subject = certs[0].Subject.String()
+	report.Issuer = certs[0].Issuer.String()
+	report.Expiry = certs[0].NotAfter
+	for _, cert := range certs {
+		switch {
+		case now.After(cert.NotAfter):
+			report.Err = fmt.Errorf("Certificate for %v expired at %v", cert.Subject, cert.NotAfter)
+		case now.Before(cert.NotBefore):
+			report.Err = fmt.Errorf("Certificate for %v is not valid until %v", cert.Subject, cert.NotBefore)
+		}
+		if report.Err != nil {
+			return report, pemBytes
+		}
+	}
+	report.Valid = true
+	return report, pemBytes
+}
@@ -0,0 +1,62 @@
+package tlsredis
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateCredentials(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	client := newClientCert(t, ca)
+	expired := issueCert(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "expired"},
+		NotBefore:   time.Now().Add(-2 * time.Hour),
+		NotAfter:    time.Now().Add(-time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM())
+	certFile := writeTestFile(t, "client.pem", client.certPEM())
+	keyFile := writeTestFile(t, "client.key", client.keyPEM())
+	expiredFile := writeTestFile(t, "expired.pem", expired.certPEM())
+	expiredKeyFile := writeTestFile(t, "expired.key", expired.keyPEM())
+
+	report, err := ValidateCredentials(&Options{RedisCAFile: caFile, ClientCertFile: certFile, ClientPKFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.CA.Valid || report.CA.Subject != "CN=Test CA" || report.CA.Issuer != "CN=Test CA" || !report.CA.Expiry.Equal(ca.cert.NotAfter) {
+		t.Errorf("Unexpected CA report %+v", report.CA)
+	}
+	if !report.ClientCert.Valid || report.ClientCert.Subject != "CN=client" || report.ClientCert.Issuer != "CN=Test CA" || report.ClientCert.Path != certFile {
+		t.Errorf("Unexpected client certificate report %+v", report.ClientCert)
+	}
+	if !report.ClientKey.Valid || report.ClientKey.Path != keyFile {
+		t.Errorf("Unexpected client key report %+v", report.ClientKey)
+	}
+
+	report, err = ValidateCredentials(&Options{RedisCAFile: certFile + ".missing", ClientCertFile: expiredFile, ClientPKFile: expiredKeyFile})
+	if err == nil || !strings.HasPrefix(err.Error(), "Invalid credentials") {
+		t.Fatalf("Expected an error summarizing the problems, got %v", err)
+	}
+	if report.CA.Valid || report.CA.Err == nil {
+		t.Errorf("Expected the missing CA file to be invalid, got %+v", report.CA)
+	}
+	if report.ClientCert.Valid || !strings.Contains(report.ClientCert.Err.Error(), "expired") || report.ClientCert.Subject != "CN=expired" {
+		t.Errorf("Expected the expired certificate to be invalid, got %+v", report.ClientCert)
+	}
+	if !report.ClientKey.Valid {
+		t.Errorf("Expected the key to match the expired certificate, got %+v", report.ClientKey)
+	}
+
+	report, err = ValidateCredentials(&Options{ClientCertFile: certFile, ClientPKFile: expiredKeyFile})
+	if err == nil || report.CA != nil || !report.ClientCert.Valid || report.ClientKey.Valid {
+		t.Errorf("Expected only the mismatched key to be invalid, got %+v (%v)", report, err)
+	}
+
+	if _, err := ValidateCredentials(&Options{ClientCertFile: certFile}); err == nil {
+		t.Error("Expected an error for a certificate without a key")
+	}
+}
@@ -0,0 +1,820 @@
+package tlsredis
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCAFileLoad is returned (wrapped) when the Redis CA file can't be read
+	// or doesn't contain any certificates.
+	ErrCAFileLoad = errors.New("Unable to load RedisCAFile")
+
+	// fipsCipherSuites are the FIPS 140-2 approved cipher suites used in
+	// FIPSMode.
+	fipsCipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+
+	// fipsCurves are the FIPS 140-2 approved curves used in FIPSMode.
+	fipsCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+
+	fetchedCAs      = make(map[string][]byte)
+	fetchedCAsMutex sync.Mutex
+
+	sessionCaches      = make(map[string]tls.ClientSessionCache)
+	sessionCachesMutex sync.Mutex
+)
+
+// BuildDialer returns the dial function that GetClient would use for opts,
+// complete with TLS, timeouts and keepalives, for use with clients that this
+// package doesn't construct. The returned function doesn't hold any per
+// connection state, so it's safe to share among multiple clients. It doesn't
+// run StartupCommands or OnConnect, since those need a client.
+func BuildDialer(opts *Options) (func() (net.Conn, error), error) {
+	if opts == nil {
+		return nil, ErrNilOptions
+	}
+	u, err := parseURL(opts.RedisURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	return buildDialFunc(context.Background(), opts, u)
+}
+
+// buildDialFunc builds the dial function for connecting to u. ctx bounds all
+// dials, including retries, and not just a single one, so it must live at least
+// as long as the client that uses the dial function.
+func buildDialFunc(ctx context.Context, opts *Options, u *url.URL) (func() (net.Conn, error), error) {
+	dialer := defaultConfigBuilder.dialer(opts)
+
+	network, target := opts.Network, u.Host
+	switch network {
+	case "":
+		network = "tcp"
+	case "tcp", "tcp4", "tcp6":
+		// supported
+	case "unix":
+		if opts.Addr == "" {
+			return nil, fmt.Errorf("Network unix requires Addr to be the path of the socket")
+		}
+		if opts.ProxyURL != "" || len(opts.Addrs) > 0 {
+			return nil, fmt.Errorf("Network unix can't be combined with ProxyURL or Addrs")
+		}
+		log.Debugf("Connecting to Redis via unix socket at %v", opts.Addr)
+		target = opts.Addr
+	default:
+		return nil, fmt.Errorf("Unsupported Network %q, must be tcp, tcp4, tcp6 or unix", network)
+	}
+
+	netDial := func(network string, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	if opts.DialFunc != nil {
+		log.Debug("Using custom DialFunc")
+		netDial = opts.DialFunc
+	}
+	if opts.TOS != 0 && network != "unix" {
+		log.Debugf("Marking connections with TOS %#x", opts.TOS)
+		tos, dial := opts.TOS, netDial
+		netDial = func(network string, addr string) (net.Conn, error) {
+			conn, err := dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			if err := setTOS(conn, tos); err != nil {
+				log.Errorf("WARNING: %v", err)
+			}
+			return conn, nil
+		}
+	}
+
+	dialAddr := func(addr string) (net.Conn, error) {
+		return netDial(network, addr)
+	}
+
+	if opts.ProxyURL != "" {
+		proxyURL, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse ProxyURL: %v", urlParseError(err))
+		}
+		if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+			return nil, fmt.Errorf("Unsupported ProxyURL scheme %q, must be http or https", proxyURL.Scheme)
+		}
+		var proxyTLS *tls.Config
+		if proxyURL.Scheme == "https" {
+			proxyTLS, err = proxyTLSConfig(proxyURL, opts.ProxyTLS)
+			if err != nil {
+				return nil, err
+			}
+		} else if opts.ProxyTLS != nil {
+			return nil, fmt.Errorf("ProxyTLS requires an https ProxyURL")
+		}
+		log.Debugf("Connecting to Redis via proxy at %v", proxyURL.Host)
+		dialAddr = func(addr string) (net.Conn, error) {
+			return dialViaProxy(ctx, netDial, dialer.Timeout, proxyURL, proxyTLS, addr)
+		}
+	}
+
+	connect := func() (net.Conn, error) {
+		return dialAddr(target)
+	}
+	if len(opts.Addrs) > 0 {
+		log.Debugf("Connecting to Redis at first reachable address of %v", opts.Addrs)
+		addrs := opts.Addrs
+		connect = func() (net.Conn, error) {
+			var err error
+			for _, addr := range addrs {
+				var conn net.Conn
+				conn, err = dialAddr(addr)
+				if err == nil {
+					return conn, nil
+				}
+				log.Debugf("Unable to connect to Redis at %v: %v", addr, err)
+			}
+			return nil, err
+		}
+	}
+
+	if opts.SendProxyProtocol {
+		if network == "unix" {
+			return nil, fmt.Errorf("SendProxyProtocol can't be used with a unix Network")
+		}
+		version := opts.ProxyProtocolVersion
+		switch version {
+		case 0:
+			version = 1
+		case 1, 2:
+			// supported
+		default:
+			return nil, fmt.Errorf("Unsupported ProxyProtocolVersion %d, must be 1 or 2", version)
+		}
+		src, err := parseProxyProtocolAddr("ProxyProtocolSourceAddr", opts.ProxyProtocolSourceAddr)
+		if err != nil {
+			return nil, err
+		}
+		dst, err := parseProxyProtocolAddr("ProxyProtocolDestAddr", opts.ProxyProtocolDestAddr)
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("Sending PROXY protocol version %d header on new connections", version)
+		connect = withProxyProtocol(connect, version, src, dst, dialer.Timeout)
+	}
+
+	if opts.MaxConnsPerHost > 0 {
+		log.Debugf("Limiting connections to %v to %d", u.Host, opts.MaxConnsPerHost)
+		connect = withConnLimit(ctx, connect, hostSemaphore(u.Host, opts.MaxConnsPerHost), dialer.Timeout)
+	}
+
+	tcpDial := func() (net.Conn, error) {
+		conn, err := connect()
+		if err != nil {
+			return nil, dialError(u, "dial", err)
+		}
+		return conn, nil
+	}
+
+	dialFunc := tcpDial
+
+	if !strings.EqualFold(u.Scheme, "rediss") {
+		if ignored := tlsOnlyOptions(opts); len(ignored) > 0 {
+			if opts.StrictTLSConfig {
+				return nil, fmt.Errorf("%v can only be used with rediss URLs, but Redis URL scheme is %q", strings.Join(ignored, ", "), u.Scheme)
+			}
+			log.Errorf("WARNING: Ignoring %v because Redis URL scheme is %q, connection is UNENCRYPTED", strings.Join(ignored, ", "), u.Scheme)
+		}
+	} else {
+		log.Debug("Using encrypted connection to Redis")
+		var tlsConfig *tls.Config
+		if opts.tlsConfig != nil {
+			log.Debug("Using caller supplied TLS configuration")
+			tlsConfig = opts.tlsConfig.Clone()
+			if tlsConfig.ServerName == "" {
+				tlsConfig.ServerName = u.Hostname()
+			}
+		} else if opts.CARefreshInterval > 0 {
+			var err error
+			tlsConfig, err = buildTLSConfig(opts, u, dialer.Timeout)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			var err error
+			tlsConfig, err = defaultConfigBuilder.get(opts, u, dialer.Timeout)
+			if err != nil {
+				return nil, err
+			}
+		}
+		currentConfig := func() *tls.Config {
+			return tlsConfig
+		}
+		if opts.CARefreshInterval > 0 && opts.tlsConfig == nil {
+			log.Debugf("Reloading Redis CA every %v", opts.CARefreshInterval)
+			optsCopy := *opts
+			optsCopy.refetchCA = true
+			refreshing := &refreshingConfig{
+				config:   tlsConfig,
+				loadedAt: now(),
+				interval: opts.CARefreshInterval,
+				build: func() (*tls.Config, error) {
+					return buildTLSConfig(&optsCopy, u, dialer.Timeout)
+				},
+			}
+			currentConfig = refreshing.get
+		}
+
+		verifyViaReverseDNS := opts.VerifyViaReverseDNS
+		if verifyViaReverseDNS {
+			if network == "unix" || opts.ProxyURL != "" {
+				return nil, fmt.Errorf("VerifyViaReverseDNS can't be used with a unix Network or ProxyURL")
+			}
+			log.Debug("Verifying server certificates against reverse DNS")
+		}
+		verifyPeerIP := opts.VerifyPeerIP
+		if verifyPeerIP {
+			if network == "unix" || opts.ProxyURL != "" {
+				return nil, fmt.Errorf("VerifyPeerIP can't be used with a unix Network or ProxyURL")
+			}
+			log.Debug("Verifying server certificates against the IP addresses connected to")
+		}
+
+		fallbackToPlaintext, onHandshakeError, connState, eventURL := opts.FallbackToPlaintext, opts.OnTLSHandshakeError, opts.connState, opts.eventURL
+		dialFunc = func() (net.Conn, error) {
+			// Like tls.DialWithDialer, the dial timeout covers both the connection
+			// and the handshake.
+			deadline := time.Now().Add(dialer.Timeout)
+			conn, err := tcpDial()
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig := currentConfig()
+			if verifyViaReverseDNS {
+				tlsConfig, err = reverseDNSConfig(ctx, tlsConfig, conn)
+				if err != nil {
+					conn.Close()
+					return nil, dialError(u, "reverse dns", err)
+				}
+			}
+			if verifyPeerIP {
+				tlsConfig, err = peerIPConfig(tlsConfig, conn)
+				if err != nil {
+					conn.Close()
+					return nil, dialError(u, "peer ip", err)
+				}
+			}
+			tlsConn, err := handshake(ctx, conn, tlsConfig, deadline)
+			if err != nil {
+				if fallbackToPlaintext && isNotTLS(err) {
+					log.Errorf("Server at %v doesn't appear to speak TLS (%v), falling back to UNENCRYPTED connection", u.Host, err)
+					return tcpDial()
+				}
+				if onHandshakeError != nil {
+					onHandshakeError(u.Host, err)
+				}
+				if eventURL != "" {
+					emitConnEvent(eventURL, ConnEvent{Type: ConnEventHandshakeError, Host: u.Host, Err: err})
+				}
+				return nil, dialError(u, "tls handshake", err)
+			}
+			recordConnectionState(u.Host, tlsConn.ConnectionState())
+			connState.set(tlsConn.ConnectionState())
+			return tlsConn, nil
+		}
+
+		if opts.Transport != nil {
+			if network == "unix" || opts.ProxyURL != "" || len(opts.Addrs) > 0 || opts.DialFunc != nil || opts.MaxConnsPerHost > 0 || opts.TOS != 0 || opts.SendProxyProtocol || verifyViaReverseDNS || verifyPeerIP || fallbackToPlaintext {
+				return nil, fmt.Errorf("Transport can't be combined with a unix Network, ProxyURL, Addrs, DialFunc, MaxConnsPerHost, TOS, SendProxyProtocol, VerifyViaReverseDNS, VerifyPeerIP or FallbackToPlaintext")
+			}
+			log.Debugf("Connecting to Redis using custom Transport %T", opts.Transport)
+			transport := opts.Transport
+			dialFunc = func() (net.Conn, error) {
+				dialCtx, cancel := context.WithTimeout(ctx, dialer.Timeout)
+				defer cancel()
+				conn, err := transport.Dial(dialCtx, network, target, currentConfig())
+				if err != nil {
+					return nil, dialError(u, "transport", err)
+				}
+				if stateful, ok := conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+					recordConnectionState(u.Host, stateful.ConnectionState())
+					connState.set(stateful.ConnectionState())
+				}
+				return conn, nil
+			}
+		}
+	}
+
+	if tracker := opts.connectTracker; tracker != nil {
+		dial := dialFunc
+		dialFunc = func() (net.Conn, error) {
+			tracker.attempt()
+			return dial()
+		}
+	}
+
+	if opts.MaxDialRetries > 0 {
+		log.Debugf("Retrying failed dials up to %d times", opts.MaxDialRetries)
+		dialFunc = withDialRetries(ctx, dialFunc, opts.isRetryable(), opts.MaxDialRetries, dialRetryBackoff(opts), !opts.DisableRetryJitter)
+	}
+
+	if opts.ConnReadDeadline > 0 || opts.ConnWriteDeadline > 0 {
+		log.Debugf("Applying connection read deadline %v and write deadline %v", opts.ConnReadDeadline, opts.ConnWriteDeadline)
+		readDeadline, writeDeadline := opts.ConnReadDeadline, opts.ConnWriteDeadline
+		dial := dialFunc
+		dialFunc = func() (net.Conn, error) {
+			conn, err := dial()
+			if err != nil {
+				return nil, err
+			}
+			return &deadlineConn{Conn: conn, readDeadline: readDeadline, writeDeadline: writeDeadline}, nil
+		}
+	}
+
+	return dialFunc, nil
+}
+
+// newNetDialer builds the net.Dialer for TCP connections to Redis from
+// DialTimeout, TCPKeepAlive, DisableTCPKeepAlive and ConnectDeadline.
+func newNetDialer(opts *Options) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout:   opts.DialTimeout,
+		KeepAlive: opts.TCPKeepAlive,
+	}
+	if dialer.Timeout == 0 {
+		dialer.Timeout = 30 * time.Second
+		log.Debugf("Defaulted dial timeout to %v", dialer.Timeout)
+	}
+	if opts.DisableTCPKeepAlive {
+		log.Debug("Disabling TCP keepalives")
+		dialer.KeepAlive = -1
+	}
+	if opts.ConnectDeadline > 0 && dialer.Timeout > opts.ConnectDeadline {
+		dialer.Timeout = opts.ConnectDeadline
+		log.Debugf("Capped dial timeout to ConnectDeadline of %v", dialer.Timeout)
+	}
+	return dialer
+}
+
+// buildTLSConfig builds the tls.Config for rediss connections to u. timeout
+// bounds fetching RedisCAURL.
+func buildTLSConfig(opts *Options, u *url.URL, timeout time.Duration) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: serverName(u),
+		// Look up now on every use rather than capturing it here, since the
+		// configuration may be memoized for a long time
+		Time: func() time.Time { return now() },
+	}
+	if opts.DisableSessionResumption {
+		log.Debug("Disabling TLS session resumption")
+		tlsConfig.SessionTicketsDisabled = true
+	} else if opts.TLSSessionCache != nil {
+		log.Debugf("Using custom TLS session cache %T", opts.TLSSessionCache)
+		tlsConfig.ClientSessionCache = opts.TLSSessionCache
+	} else if opts.ShareSessionCache {
+		log.Debugf("Sharing TLS session cache for %v", u.Host)
+		tlsConfig.ClientSessionCache = sharedSessionCache(u.Host)
+	} else {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1000)
+	}
+
+	if opts.InsecureSkipVerify {
+		log.Errorf("Not verifying Redis server certificate, connection is vulnerable to man-in-the-middle attacks")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	if opts.FIPSMode {
+		log.Debug("Restricting TLS to FIPS approved algorithms")
+		tlsConfig.MinVersion = tls.VersionTLS12
+		tlsConfig.MaxVersion = tls.VersionTLS12
+		tlsConfig.CipherSuites = fipsCipherSuites
+		tlsConfig.CurvePreferences = fipsCurves
+	}
+
+	if len(opts.CurvePreferences) > 0 {
+		for _, curve := range opts.CurvePreferences {
+			if strings.HasPrefix(curve.String(), "CurveID(") {
+				return nil, fmt.Errorf("Unknown curve in CurvePreferences: %d", curve)
+			}
+			if opts.FIPSMode && !containsCurve(fipsCurves, curve) {
+				return nil, fmt.Errorf("Curve %v in CurvePreferences is not FIPS approved", curve)
+			}
+		}
+		log.Debugf("Using curve preferences %v", opts.CurvePreferences)
+		tlsConfig.CurvePreferences = opts.CurvePreferences
+	}
+
+	if opts.Renegotiation != tls.RenegotiateNever {
+		log.Debug("Allowing TLS renegotiation")
+		tlsConfig.Renegotiation = opts.Renegotiation
+	}
+
+	caFile, certFile, pkFile := opts.credentialFiles()
+	if caFile == "" {
+		log.Debugf("Not using custom Redis CA")
+	} else {
+		log.Debugf("Adding custom Redis CA from: %v", caFile)
+		pool, err := loadCAFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.RedisCADir != "" {
+		caDir := opts.expandPath(opts.RedisCADir)
+		log.Debugf("Adding custom Redis CAs from directory: %v", caDir)
+		if tlsConfig.RootCAs == nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+		}
+		if err := loadCADir(caDir, tlsConfig.RootCAs); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.RedisCAURL != "" {
+		log.Debugf("Adding custom Redis CA from: %v", opts.RedisCAURL)
+		pemBytes, err := fetchCA(opts.RedisCAURL, timeout, opts.refetchCA)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load RedisCAURL: %v", err)
+		}
+		if tlsConfig.RootCAs == nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+		}
+		appendCertsFromPEM(tlsConfig.RootCAs, pemBytes, opts.RedisCAURL)
+	}
+
+	switch {
+	case pkFile == "" && certFile == "":
+		log.Debug("Not enabling client TLS authentication")
+	case pkFile == "":
+		return nil, fmt.Errorf("ClientCertFile %v was given without a ClientPKFile", certFile)
+	case certFile == "":
+		return nil, fmt.Errorf("ClientPKFile %v was given without a ClientCertFile", pkFile)
+	default:
+		log.Debugf("Enabling client TLS authentication using pk %v and cert %v", pkFile, certFile)
+		cert, err := loadClientCert(certFile, pkFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(opts.ClientCertificates) > 0 {
+		log.Debugf("Enabling client TLS authentication using %d additional certificates", len(opts.ClientCertificates))
+		tlsConfig.Certificates = append(tlsConfig.Certificates, opts.ClientCertificates...)
+	}
+
+	if len(opts.AllowedServerNames) > 0 {
+		if opts.VerifyServerName != "" {
+			return nil, fmt.Errorf("AllowedServerNames and VerifyServerName can't be used together")
+		}
+		log.Debugf("Accepting server certificates valid for any of %v", opts.AllowedServerNames)
+		verifyServerNames(tlsConfig, opts.AllowedServerNames)
+	}
+
+	if opts.VerifyViaReverseDNS && (len(opts.AllowedServerNames) > 0 || opts.VerifyServerName != "") {
+		return nil, fmt.Errorf("VerifyViaReverseDNS can't be combined with AllowedServerNames or VerifyServerName")
+	}
+
+	if opts.VerifyPeerIP && (len(opts.AllowedServerNames) > 0 || opts.VerifyServerName != "" || opts.VerifyViaReverseDNS) {
+		return nil, fmt.Errorf("VerifyPeerIP can't be combined with AllowedServerNames, VerifyServerName or VerifyViaReverseDNS")
+	}
+
+	if opts.VerifyServerName != "" {
+		log.Debugf("Sending SNI %v but verifying server certificate for %v", tlsConfig.ServerName, opts.VerifyServerName)
+		verifyServerNames(tlsConfig, []string{opts.VerifyServerName})
+	}
+
+	if opts.RequireServerAuthEKU {
+		log.Debug("Requiring serverAuth extended key usage on server certificates")
+		addVerifier(tlsConfig, verifyServerAuthEKU)
+	}
+
+	if len(opts.PinnedSerials) > 0 || len(opts.PinnedSubjects) > 0 {
+		verify, err := pinnedLeafVerifier(opts.PinnedSerials, opts.PinnedSubjects)
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("Pinning server certificates to serials %v and subjects %v", opts.PinnedSerials, opts.PinnedSubjects)
+		addVerifier(tlsConfig, verify)
+	}
+
+	if len(opts.AllowedSignatureAlgorithms) > 0 {
+		log.Debugf("Only accepting server certificates signed with %v", opts.AllowedSignatureAlgorithms)
+		addVerifier(tlsConfig, signatureAlgorithmVerifier(opts.AllowedSignatureAlgorithms))
+	}
+
+	if opts.TLSConfigHook != nil {
+		opts.TLSConfigHook(tlsConfig)
+	}
+
+	return tlsConfig, nil
+}
+
+// tlsOnlyOptions returns the names of the options set in opts that only apply
+// to rediss connections.
+func tlsOnlyOptions(opts *Options) []string {
+	var names []string
+	add := func(name string, set bool) {
+		if set {
+			names = append(names, name)
+		}
+	}
+	add("RedisCAFile", opts.RedisCAFile != "")
+	add("RedisCAURL", opts.RedisCAURL != "")
+	add("RedisCADir", opts.RedisCADir != "")
+	add("ClientCertFile", opts.ClientCertFile != "")
+	add("ClientPKFile", opts.ClientPKFile != "")
+	add("ClientCertificates", len(opts.ClientCertificates) > 0)
+	add("CredsDir", opts.CredsDir != "")
+	add("InsecureSkipVerify", opts.InsecureSkipVerify)
+	add("AllowedServerNames", len(opts.AllowedServerNames) > 0)
+	add("VerifyServerName", opts.VerifyServerName != "")
+	add("VerifyViaReverseDNS", opts.VerifyViaReverseDNS)
+	add("VerifyPeerIP", opts.VerifyPeerIP)
+	add("RequireServerAuthEKU", opts.RequireServerAuthEKU)
+	add("PinnedSerials", len(opts.PinnedSerials) > 0)
+	add("PinnedSubjects", len(opts.PinnedSubjects) > 0)
+	add("AllowedSignatureAlgorithms", len(opts.AllowedSignatureAlgorithms) > 0)
+	add("FIPSMode", opts.FIPSMode)
+	add("CurvePreferences", len(opts.CurvePreferences) > 0)
+	add("Renegotiation", opts.Renegotiation != tls.RenegotiateNever)
+	add("CARefreshInterval", opts.CARefreshInterval > 0)
+	add("TLSConfigHook", opts.TLSConfigHook != nil)
+	add("Transport", opts.Transport != nil)
+	add("TLSSessionCache", opts.TLSSessionCache != nil)
+	return names
+}
+
+func containsCurve(curves []tls.CurveID, curve tls.CurveID) bool {
+	for _, c := range curves {
+		if c == curve {
+			return true
+		}
+	}
+	return false
+}
+
+// serverName returns the name against which to verify the certificate of the
+// server at u. This is the URL's host without the port or, for IPv6 link-local
+// addresses like fe80::1%eth0, the zone. The zone is only meaningful for
+// dialing, which uses the host as-is.
+func serverName(u *url.URL) string {
+	host := u.Hostname()
+	if i := strings.IndexByte(host, '%'); i >= 0 && strings.Contains(host, ":") {
+		host = host[:i]
+	}
+	return host
+}
+
+// dialError describes a failure to connect to u during phase, identifying the
+// target without including any credentials from u.
+func dialError(u *url.URL, phase string, err error) error {
+	return fmt.Errorf("Unable to connect to %v://%v (%v): %w", u.Scheme, u.Host, phase, err)
+}
+
+// handshake performs a client TLS handshake over conn, which is closed if the
+// handshake fails or ctx is done first.
+func handshake(ctx context.Context, conn net.Conn, tlsConfig *tls.Config, deadline time.Time) (*tls.Conn, error) {
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// isNotTLS reports whether err from a TLS handshake looks like the server isn't
+// speaking TLS, either because it answered with something that isn't a TLS
+// record or because it hung up on our ClientHello.
+func isNotTLS(err error) bool {
+	var recordHeaderErr tls.RecordHeaderError
+	return errors.As(err, &recordHeaderErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// deadlineConn is a net.Conn that pushes out its read and/or write deadline
+// before every Read and Write.
+type deadlineConn struct {
+	net.Conn
+	readDeadline  time.Duration
+	writeDeadline time.Duration
+}
+
+func (conn *deadlineConn) Read(b []byte) (int, error) {
+	if conn.readDeadline > 0 {
+		if err := conn.Conn.SetReadDeadline(time.Now().Add(conn.readDeadline)); err != nil {
+			return 0, err
+		}
+	}
+	return conn.Conn.Read(b)
+}
+
+func (conn *deadlineConn) Write(b []byte) (int, error) {
+	if conn.writeDeadline > 0 {
+		if err := conn.Conn.SetWriteDeadline(time.Now().Add(conn.writeDeadline)); err != nil {
+			return 0, err
+		}
+	}
+	return conn.Conn.Write(b)
+}
+
+// sharedSessionCache returns the TLS session cache shared by all clients for
+// host, creating it if necessary. The caches themselves are safe for concurrent
+// use.
+func sharedSessionCache(host string) tls.ClientSessionCache {
+	sessionCachesMutex.Lock()
+	defer sessionCachesMutex.Unlock()
+
+	cache, ok := sessionCaches[host]
+	if !ok {
+		cache = tls.NewLRUClientSessionCache(1000)
+		sessionCaches[host] = cache
+	}
+	return cache
+}
+
+// loadCAFile loads all PEM encoded certificates in caFile into a new pool. A
+// file that contains no certificates at all is treated as an error, rather
+// than leaving the handshake to fail with an unknown authority. Anything else
+// in the file is ignored with a warning.
+func loadCAFile(caFile string) (*x509.CertPool, error) {
+	pemBytes, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w %v: %v", ErrCAFileLoad, caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if appendCertsFromPEM(pool, pemBytes, caFile) == 0 {
+		return nil, fmt.Errorf("%w %v: no certificates found", ErrCAFileLoad, caFile)
+	}
+	return pool, nil
+}
+
+// appendCertsFromPEM is like x509.CertPool.AppendCertsFromPEM but returns the
+// number of certificates added and logs a warning about anything in pemBytes
+// that isn't a valid certificate, like corrupt blocks or trailing garbage, so
+// that a damaged CA file doesn't silently leave us trusting only part of it.
+// source names where pemBytes came from for the warning. If nothing could be
+// added, it's up to the caller to report that.
+func appendCertsFromPEM(pool *x509.CertPool, pemBytes []byte, source string) int {
+	added := 0
+	var ignored []string
+	for rest := pemBytes; len(rest) > 0; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			if len(bytes.TrimSpace(rest)) > 0 {
+				ignored = append(ignored, fmt.Sprintf("%d bytes of trailing data", len(rest)))
+			}
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			ignored = append(ignored, fmt.Sprintf("a %v block", block.Type))
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			ignored = append(ignored, fmt.Sprintf("an invalid certificate (%v)", err))
+			continue
+		}
+		pool.AddCert(cert)
+		added++
+	}
+	if added > 0 && len(ignored) > 0 {
+		log.Errorf("WARNING: Loaded %d certificates from %v but ignored %v", added, source, strings.Join(ignored, ", "))
+	}
+	return added
+}
+
+// loadCADir adds the certificates from all .pem and .crt files under caDir to
+// pool. Files that can't be loaded are skipped, but it's an error if caDir
+// can't be walked or none of the files contain a certificate.
+func loadCADir(caDir string, pool *x509.CertPool) error {
+	loaded := 0
+	err := filepath.Walk(caDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == caDir {
+				return err
+			}
+			log.Debugf("Skipping %v: %v", path, err)
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".pem", ".crt":
+		default:
+			return nil
+		}
+		pemBytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Debugf("Skipping CA file %v: %v", path, err)
+			return nil
+		}
+		if appendCertsFromPEM(pool, pemBytes, path) == 0 {
+			log.Debugf("Skipping CA file %v: no certificates found", path)
+			return nil
+		}
+		loaded++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to load RedisCADir %v: %v", caDir, err)
+	}
+	if loaded == 0 {
+		return fmt.Errorf("Unable to load RedisCADir %v: no certificates found", caDir)
+	}
+	return nil
+}
+
+// loadClientCert loads the client certificate chain from certFile and its
+// private key from pkFile. This is independent of the CA used to verify the
+// server, so the chain may be issued by an unrelated CA.
+func loadClientCert(certFile string, pkFile string) (tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Unable to load ClientCertFile %v: %v", certFile, err)
+	}
+	if block, _ := pem.Decode(certPEM); block == nil {
+		return tls.Certificate{}, fmt.Errorf("Unable to load ClientCertFile %v: no PEM data found", certFile)
+	}
+	pkPEM, err := ioutil.ReadFile(pkFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Unable to load ClientPKFile %v: %v", pkFile, err)
+	}
+	if block, _ := pem.Decode(pkPEM); block == nil {
+		return tls.Certificate{}, fmt.Errorf("Unable to load ClientPKFile %v: no PEM data found", pkFile)
+	}
+	cert, err := tls.X509KeyPair(certPEM, pkPEM)
+	if err != nil {
+		var fallbackErr error
+		cert, fallbackErr = keyPairFromPEM(certPEM, pkPEM)
+		if fallbackErr != nil {
+			return tls.Certificate{}, fmt.Errorf("Unable to load Client certificate/key pair from %v and %v: %v (%v)", certFile, pkFile, err, fallbackErr)
+		}
+		log.Debugf("Loaded client key from %v despite unusual format: %v", pkFile, err)
+	}
+	return cert, nil
+}
+
+// fetchCA returns the PEM-encoded certificates served at caURL, fetching them
+// if we haven't already or if refetch is true.
+func fetchCA(caURL string, timeout time.Duration, refetch bool) ([]byte, error) {
+	fetchedCAsMutex.Lock()
+	pemBytes, ok := fetchedCAs[caURL]
+	fetchedCAsMutex.Unlock()
+	if ok && !refetch {
+		return pemBytes, nil
+	}
+
+	// Don't hold the lock while fetching, so that a slow or unreachable CA
+	// server doesn't hold up clients using other CA URLs.
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(caURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected response status from %v: %v", caURL, resp.Status)
+	}
+	pemBytes, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read response from %v: %v", caURL, err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("No PEM-encoded certificates found at %v", caURL)
+	}
+
+	fetchedCAsMutex.Lock()
+	fetchedCAs[caURL] = pemBytes
+	fetchedCAsMutex.Unlock()
+	return pemBytes, nil
+}
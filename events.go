@@ -0,0 +1,127 @@
+package tlsredis
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// connEventBufferSize is the number of events each channel returned by Events
+// buffers.
+const connEventBufferSize = 100
+
+var (
+	connEventSubscribers      = make(map[string][]chan ConnEvent)
+	connEventSubscribersMutex sync.RWMutex
+)
+
+// ConnEventType identifies the kind of a ConnEvent.
+type ConnEventType int
+
+const (
+	// ConnEventConnect means that a new connection to Redis is ready for use.
+	ConnEventConnect ConnEventType = iota
+
+	// ConnEventDisconnect means that a connection to Redis was closed.
+	ConnEventDisconnect
+
+	// ConnEventHandshakeError means that a TLS handshake with Redis failed.
+	ConnEventHandshakeError
+)
+
+func (t ConnEventType) String() string {
+	switch t {
+	case ConnEventConnect:
+		return "connect"
+	case ConnEventDisconnect:
+		return "disconnect"
+	case ConnEventHandshakeError:
+		return "handshake-error"
+	default:
+		return "unknown"
+	}
+}
+
+// ConnEvent is something that happened to a connection of a client, as
+// reported by Events.
+type ConnEvent struct {
+	Type ConnEventType
+
+	// Host is the host:port of Redis.
+	Host string
+
+	// Time is when the event happened.
+	Time time.Time
+
+	// Err is the error behind ConnEventHandshakeError events.
+	Err error
+}
+
+// Events returns a channel on which the connections of clients for redisURL
+// (identified like by CachedURLs, so credentials in redisURL are ignored) are
+// reported as they're made, closed or fail their TLS handshake. This covers
+// connections that clients make in the background, like reconnects after
+// Redis restarted, as well as clients created after calling Events.
+//
+// The channel buffers up to 100 events. If the consumer falls behind and the
+// buffer is full, further events are dropped rather than holding up the
+// connections. Each call creates a new channel that receives events for the
+// life of the process, so call it once per URL rather than repeatedly. If
+// redisURL can't be parsed, the channel is closed straight away.
+func Events(redisURL string) <-chan ConnEvent {
+	ch := make(chan ConnEvent, connEventBufferSize)
+	u, err := parseURL(redisURL, &Options{})
+	if err != nil {
+		log.Errorf("Unable to subscribe to events: %v", err)
+		close(ch)
+		return ch
+	}
+	target := endpointURL(u, dbFromPath(u))
+	connEventSubscribersMutex.Lock()
+	connEventSubscribers[target] = append(connEventSubscribers[target], ch)
+	connEventSubscribersMutex.Unlock()
+	return ch
+}
+
+// emitConnEvent sends event to all subscribers for the endpoint URL target
+// that have room for it.
+func emitConnEvent(target string, event ConnEvent) {
+	event.Time = now()
+	connEventSubscribersMutex.RLock()
+	defer connEventSubscribersMutex.RUnlock()
+	for _, ch := range connEventSubscribers[target] {
+		select {
+		case ch <- event:
+		default:
+			log.Debugf("Dropping %v event for %v, subscriber isn't keeping up", event.Type, target)
+		}
+	}
+}
+
+// withConnEvents wraps dial so that new connections and their closing are
+// reported as events for the endpoint URL target.
+func withConnEvents(dial func() (net.Conn, error), target string, host string) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		conn, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		emitConnEvent(target, ConnEvent{Type: ConnEventConnect, Host: host})
+		return &eventConn{Conn: conn, target: target, host: host}, nil
+	}
+}
+
+// eventConn is a net.Conn that reports being closed as a ConnEventDisconnect.
+type eventConn struct {
+	net.Conn
+	target string
+	host   string
+	once   sync.Once
+}
+
+func (conn *eventConn) Close() error {
+	conn.once.Do(func() {
+		emitConnEvent(conn.target, ConnEvent{Type: ConnEventDisconnect, Host: conn.host})
+	})
+	return conn.Conn.Close()
+}
@@ -0,0 +1,83 @@
+package tlsredis
+
+import (
+	"testing"
+	"time"
+)
+
+// nextEvent waits for the next event on events.
+func nextEvent(t *testing.T, events <-chan ConnEvent) ConnEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+		return ConnEvent{}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	// Credentials don't matter for identifying the client
+	events := Events("redis://:secret@" + srv.addr)
+
+	rc, err := GetClient(&Options{RedisURL: srv.url()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, events); event.Type != ConnEventConnect || event.Host != srv.addr || event.Time.IsZero() {
+		t.Errorf("Expected a connect event for %v, got %+v", srv.addr, event)
+	}
+
+	// Force a reconnect
+	srv.dropConns()
+	rc.Ping()
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, events); event.Type != ConnEventDisconnect {
+		t.Errorf("Expected the dropped connection to be reported, got %+v", event)
+	}
+	if event := nextEvent(t, events); event.Type != ConnEventConnect {
+		t.Errorf("Expected the reconnect to be reported, got %+v", event)
+	}
+}
+
+func TestHandshakeErrorEvents(t *testing.T) {
+	srv, _ := startTLSFakeRedis(t)
+	closeClientOnCleanup(t, srv.url())
+	events := Events(srv.url())
+
+	otherCA := writeTestFile(t, "other.pem", newTestCA(t, "Other CA").certPEM())
+	rc, err := GetClient(&Options{RedisURL: srv.url(), RedisCAFile: otherCA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err == nil {
+		t.Fatal("Expected a certificate from another CA to fail the handshake")
+	}
+	if event := nextEvent(t, events); event.Type != ConnEventHandshakeError || event.Err == nil {
+		t.Errorf("Expected a handshake-error event with its error, got %+v", event)
+	}
+}
+
+func TestEventsBuffering(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	events := Events(srv.url())
+	// A consumer that doesn't keep up doesn't hold up the connections
+	for i := 0; i < connEventBufferSize+10; i++ {
+		emitConnEvent("redis://"+srv.addr+"/0", ConnEvent{Type: ConnEventConnect})
+	}
+	if len(events) != connEventBufferSize {
+		t.Errorf("Expected %d buffered events, got %d", connEventBufferSize, len(events))
+	}
+
+	if _, ok := <-Events("redis://%zz"); ok {
+		t.Error("Expected the channel for an unparseable URL to be closed")
+	}
+}
@@ -0,0 +1,102 @@
+package tlsredis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// isHealthyTimeout bounds the PINGs made by IsHealthy.
+const isHealthyTimeout = 2 * time.Second
+
+// IsHealthy reports whether the cached clients for redisURL (as listed by
+// CachedURLs) can currently PING Redis, giving up after a couple of seconds.
+// It never creates a client, so it returns false if none is cached.
+func IsHealthy(redisURL string) bool {
+	u, err := parseURL(redisURL, &Options{})
+	if err != nil {
+		return false
+	}
+	target := endpointURL(u, dbFromPath(u))
+
+	rcsMutex.Lock()
+	var clients []*redis.Client
+	for _, cc := range rcs {
+		if cc.url == target {
+			clients = append(clients, cc.client)
+		}
+	}
+	rcsMutex.Unlock()
+	if len(clients) == 0 {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), isHealthyTimeout)
+	defer cancel()
+	for _, rc := range clients {
+		err := RunWithContext(ctx, rc, func(rc *redis.Client) error {
+			return rc.Ping().Err()
+		})
+		if err != nil {
+			log.Debugf("Redis at %v is unhealthy: %v", u.Host, err)
+			return false
+		}
+	}
+	return true
+}
+
+// TestConnection checks that a connection to Redis can be established using
+// opts, without creating a client or touching the cache. It makes a single
+// connection, including the TLS handshake for rediss URLs, and if
+// VerifyOnConnect is set, authenticates and PINGs Redis on it before closing
+// it again. Dials are retried according to MaxDialRetries, and ctx bounds the
+// whole check. This is handy for probes that shouldn't hold on to a pool.
+func TestConnection(ctx context.Context, opts *Options) error {
+	if opts == nil {
+		return ErrNilOptions
+	}
+	u, err := parseURL(opts.RedisURL, opts)
+	if err != nil {
+		return err
+	}
+	dial, err := buildDialFunc(ctx, opts, u)
+	if err != nil {
+		return err
+	}
+	conn, err := dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if !opts.VerifyOnConnect {
+		return nil
+	}
+
+	password, err := resolvePassword(opts, u)
+	if err != nil {
+		return err
+	}
+	rc := redis.NewClient(&redis.Options{
+		Dialer: func() (net.Conn, error) {
+			return &noCloseConn{conn}, nil
+		},
+		Password:     password,
+		DB:           opts.db(u),
+		ReadTimeout:  opts.ReadTimeout,
+		WriteTimeout: opts.WriteTimeout,
+		PoolSize:     1,
+		// Disable the idle connection reaper
+		IdleCheckFrequency: -1,
+	})
+	defer rc.Close()
+	err = RunWithContext(ctx, rc, func(rc *redis.Client) error {
+		return rc.Ping().Err()
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to PING Redis at %v: %v", u.Host, err)
+	}
+	return nil
+}
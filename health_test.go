@@ -0,0 +1,76 @@
+package tlsredis
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestIsHealthy(t *testing.T) {
+	healthy := startFakeRedis(t, nil)
+	broken := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, healthy.url())
+	closeClientOnCleanup(t, broken.url())
+
+	if IsHealthy(healthy.url()) {
+		t.Error("Expected no client to mean unhealthy")
+	}
+	if len(healthy.recorded()) != 0 || len(CachedURLs()) != 0 {
+		t.Fatal("Expected IsHealthy not to create a client")
+	}
+
+	for _, srv := range []*fakeRedis{healthy, broken} {
+		if _, err := GetClient(&Options{RedisURL: srv.url()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	broken.close()
+
+	if !IsHealthy(healthy.url()) {
+		t.Error("Expected the client for the working server to be healthy")
+	}
+	if IsHealthy(broken.url()) {
+		t.Error("Expected the client for the stopped server to be unhealthy")
+	}
+	if IsHealthy("::not a url") {
+		t.Error("Expected an invalid URL to be unhealthy")
+	}
+}
+
+func TestTestConnection(t *testing.T) {
+	srv, caFile := startTLSFakeRedis(t)
+	ctx := context.Background()
+
+	if err := TestConnection(ctx, &Options{RedisURL: srv.url(), RedisCAFile: caFile}); err != nil {
+		t.Errorf("Expected to connect to a healthy server, got %v", err)
+	}
+	if len(srv.recorded()) != 0 {
+		t.Errorf("Expected no commands without VerifyOnConnect, got %v", srv.recorded())
+	}
+	if len(CachedURLs()) != 0 {
+		t.Error("Expected TestConnection not to create a client")
+	}
+
+	otherCA := writeTestFile(t, "other.pem", newTestCA(t, "Other CA").certPEM())
+	if err := TestConnection(ctx, &Options{RedisURL: srv.url(), RedisCAFile: otherCA}); err == nil {
+		t.Error("Expected an error for a server certificate from another CA")
+	}
+
+	opts := &Options{RedisURL: "rediss://:secret@" + srv.addr + "/2", RedisCAFile: caFile, VerifyOnConnect: true}
+	if err := TestConnection(ctx, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.received("AUTH", "secret") || !srv.received("SELECT", "2") || !srv.received("PING") {
+		t.Errorf("Expected AUTH, SELECT and PING with VerifyOnConnect, got %v", srv.recorded())
+	}
+
+	srv.setReply(func(args []string) string {
+		if args[0] == "PING" {
+			return "-LOADING Redis is loading the dataset in memory\r\n"
+		}
+		return ""
+	})
+	if err := TestConnection(ctx, opts); err == nil || !strings.Contains(err.Error(), "Unable to PING") {
+		t.Errorf("Expected a failed PING to be reported, got %v", err)
+	}
+}
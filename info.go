@@ -0,0 +1,56 @@
+package tlsredis
+
+import (
+	"strings"
+
+	"gopkg.in/redis.v5"
+)
+
+// ConnInfo describes the client returned by GetClientInfo.
+type ConnInfo struct {
+	// Addr is the host:port from RedisURL, with the default port filled in if
+	// it had none.
+	Addr string
+
+	// DB is the database the client uses.
+	DB int
+
+	// TLS indicates whether the client connects using TLS (a rediss URL).
+	TLS bool
+
+	// TLSVersion is the TLS version negotiated on the client's most recent TLS
+	// connection, e.g. tls.VersionTLS13, or 0 if there hasn't been one yet.
+	TLSVersion uint16
+
+	// FromCache indicates whether the client was already in the cache rather
+	// than newly created.
+	FromCache bool
+}
+
+// GetClientInfo is like GetClient but also returns information about the
+// client.
+func GetClientInfo(opts *Options) (*redis.Client, *ConnInfo, error) {
+	if opts == nil {
+		return nil, nil, ErrNilOptions
+	}
+	u, err := parseURL(opts.RedisURL, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	db := opts.db(u)
+	cc, created, err := getOrCreateClient(opts, u, db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := &ConnInfo{
+		Addr:      u.Host,
+		DB:        db,
+		TLS:       strings.EqualFold(u.Scheme, "rediss"),
+		FromCache: !created,
+	}
+	if state, found := cc.connState.get(); found {
+		info.TLSVersion = state.Version
+	}
+	return cc.client, info, nil
+}
@@ -0,0 +1,45 @@
+package tlsredis
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestGetClientInfo(t *testing.T) {
+	plaintext := startFakeRedis(t, nil)
+	secure, caFile := startTLSFakeRedis(t)
+	closeClientOnCleanup(t, plaintext.url()+"/3")
+	closeClientOnCleanup(t, secure.url())
+	closeClientOnCleanup(t, secure.url()+"/1")
+	closeClientOnCleanup(t, "redis://localhost")
+
+	for _, tc := range []struct {
+		opts     *Options
+		expected ConnInfo
+	}{
+		{&Options{RedisURL: plaintext.url() + "/3"}, ConnInfo{Addr: plaintext.addr, DB: 3}},
+		{&Options{RedisURL: "redis://localhost"}, ConnInfo{Addr: "localhost:6379"}},
+		{&Options{RedisURL: secure.url(), RedisCAFile: caFile, VerifyOnConnect: true}, ConnInfo{Addr: secure.addr, TLS: true, TLSVersion: tls.VersionTLS13}},
+		// This client hasn't connected yet, even though another one for the host has
+		{&Options{RedisURL: secure.url() + "/1", RedisCAFile: caFile}, ConnInfo{Addr: secure.addr, DB: 1, TLS: true}},
+	} {
+		rc, info, err := GetClientInfo(tc.opts)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.opts.RedisURL, err)
+		}
+		if *info != tc.expected {
+			t.Errorf("%v: expected %+v, got %+v", tc.opts.RedisURL, tc.expected, *info)
+		}
+		again, info, err := GetClientInfo(tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again != rc || !info.FromCache {
+			t.Errorf("%v: expected the second call to return the cached client", tc.opts.RedisURL)
+		}
+	}
+
+	if _, _, err := GetClientInfo(nil); err != ErrNilOptions {
+		t.Errorf("Expected ErrNilOptions, got %v", err)
+	}
+}
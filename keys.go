@@ -0,0 +1,78 @@
+package tlsredis
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// keyPairFromPEM is a fallback for when tls.X509KeyPair can't make sense of a
+// client key, typically because its PEM block has an unusual header. It parses
+// the key according to the block type, falling back to trying PKCS#8, PKCS#1
+// and EC in turn if that doesn't work.
+func keyPairFromPEM(certPEM []byte, pkPEM []byte) (tls.Certificate, error) {
+	var cert tls.Certificate
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return cert, errors.New("No certificates found")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return cert, fmt.Errorf("Unable to parse certificate: %v", err)
+	}
+
+	block, _ := pem.Decode(pkPEM)
+	if block == nil {
+		return cert, errors.New("No private key found")
+	}
+	key, err := parsePrivateKey(block)
+	if err != nil {
+		return cert, err
+	}
+	pub, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(key.Public()) {
+		return cert, errors.New("Private key doesn't match certificate")
+	}
+	cert.PrivateKey = key
+	cert.Leaf = leaf
+	return cert, nil
+}
+
+// parsePrivateKey parses block as a PKCS#1 RSA, PKCS#8 or SEC 1 EC private key.
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	parsers := map[string]func([]byte) (interface{}, error){
+		"RSA PRIVATE KEY": func(der []byte) (interface{}, error) { return x509.ParsePKCS1PrivateKey(der) },
+		"PRIVATE KEY":     x509.ParsePKCS8PrivateKey,
+		"EC PRIVATE KEY":  func(der []byte) (interface{}, error) { return x509.ParseECPrivateKey(der) },
+	}
+	// Try the parser matching the block type first, but don't trust the type
+	candidates := []string{block.Type, "PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY"}
+	for _, candidate := range candidates {
+		parse := parsers[candidate]
+		if parse == nil {
+			continue
+		}
+		key, err := parse(block.Bytes)
+		if err != nil {
+			continue
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("Unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	return nil, fmt.Errorf("Unsupported private key format %q, expected a PKCS#1, PKCS#8 or EC private key", block.Type)
+}
@@ -0,0 +1,80 @@
+package tlsredis
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyFormats(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca.cert, &rsaKey.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCertFile := writeTestFile(t, "rsa.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	rsaPKCS8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec := newClientCert(t, ca)
+	ecCertFile := writeTestFile(t, "ec.pem", ec.certPEM())
+	ecSEC1, err := x509.MarshalECPrivateKey(ec.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPKCS8, err := x509.MarshalPKCS8PrivateKey(ec.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		certFile string
+		key      *pem.Block
+	}{
+		{"PKCS#1 RSA", rsaCertFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}},
+		{"PKCS#8 RSA", rsaCertFile, &pem.Block{Type: "PRIVATE KEY", Bytes: rsaPKCS8}},
+		{"PKCS#8 RSA with PKCS#1 header", rsaCertFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: rsaPKCS8}},
+		{"EC", ecCertFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecSEC1}},
+		{"PKCS#8 EC", ecCertFile, &pem.Block{Type: "PRIVATE KEY", Bytes: ecPKCS8}},
+		{"EC with PKCS#8 header", ecCertFile, &pem.Block{Type: "PRIVATE KEY", Bytes: ecSEC1}},
+	} {
+		pkFile := writeTestFile(t, "key.pem", pem.EncodeToMemory(tc.key))
+		cert, err := loadClientCert(tc.certFile, pkFile)
+		if err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+		} else if cert.PrivateKey == nil || len(cert.Certificate) != 1 {
+			t.Errorf("%v: incomplete certificate %+v", tc.name, cert)
+		}
+	}
+
+	pkFile := writeTestFile(t, "key.pem", pem.EncodeToMemory(&pem.Block{Type: "DSA PRIVATE KEY", Bytes: []byte("junk")}))
+	_, err = loadClientCert(ecCertFile, pkFile)
+	if err == nil || !strings.Contains(err.Error(), `"DSA PRIVATE KEY"`) {
+		t.Errorf("Expected an error naming the unsupported key type, got %v", err)
+	}
+
+	// A valid key that belongs to another certificate
+	pkFile = writeTestFile(t, "key.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rsaPKCS8}))
+	if _, err := loadClientCert(ecCertFile, pkFile); err == nil {
+		t.Error("Expected an error for a key that doesn't match the certificate")
+	}
+}
@@ -0,0 +1,54 @@
+package tlsredis
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	structuredLogger      StructuredLogger = gologLogger{}
+	structuredLoggerMutex sync.RWMutex
+)
+
+// StructuredLogger receives log entries that carry key/value fields such as
+// host, scheme, db and tls, for use with log aggregation systems. Install an
+// implementation with SetLogger.
+type StructuredLogger interface {
+	// Debugw logs msg at debug level along with alternating keys and values.
+	Debugw(msg string, keysAndValues ...interface{})
+}
+
+// SetLogger installs l to receive structured log entries. Passing nil restores
+// the default, which logs them as text via golog.
+func SetLogger(l StructuredLogger) {
+	if l == nil {
+		l = gologLogger{}
+	}
+	structuredLoggerMutex.Lock()
+	structuredLogger = l
+	structuredLoggerMutex.Unlock()
+}
+
+func debugw(msg string, keysAndValues ...interface{}) {
+	structuredLoggerMutex.RLock()
+	l := structuredLogger
+	structuredLoggerMutex.RUnlock()
+	l.Debugw(msg, keysAndValues...)
+}
+
+// gologLogger logs structured entries as text like "msg key=value key=value".
+type gologLogger struct{}
+
+func (gologLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		var value interface{}
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		fmt.Fprintf(&b, " %v=%v", keysAndValues[i], value)
+	}
+	log.Debug(b.String())
+}
@@ -0,0 +1,54 @@
+package tlsredis
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// captureLogger records the structured log entries it receives.
+type captureLogger struct {
+	mx      sync.Mutex
+	entries []logEntry
+}
+
+type logEntry struct {
+	msg    string
+	fields map[string]interface{}
+}
+
+func (l *captureLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	entry := logEntry{msg: msg, fields: make(map[string]interface{})}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		entry.fields[keysAndValues[i].(string)] = keysAndValues[i+1]
+	}
+	l.mx.Lock()
+	l.entries = append(l.entries, entry)
+	l.mx.Unlock()
+}
+
+func TestStructuredLogging(t *testing.T) {
+	l := &captureLogger{}
+	SetLogger(l)
+	defer SetLogger(nil)
+
+	rc, err := GetClientContext(context.Background(), &Options{RedisURL: "rediss://redis.example.com:6380/4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Close()
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	for _, entry := range l.entries {
+		if entry.msg != "Connecting to Redis" {
+			continue
+		}
+		if entry.fields["host"] != "redis.example.com:6380" || entry.fields["db"] != 4 ||
+			entry.fields["scheme"] != "rediss" || entry.fields["tls"] != true {
+			t.Errorf("Unexpected fields %v", entry.fields)
+		}
+		return
+	}
+	t.Errorf("Expected a connect entry, got %v", l.entries)
+}
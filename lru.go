@@ -0,0 +1,47 @@
+package tlsredis
+
+// maxCachedClients and useCounter are protected by rcsMutex.
+var (
+	maxCachedClients int
+	useCounter       uint64
+)
+
+// SetMaxCachedClients limits the number of clients that GetClient and friends
+// keep cached to n. Once the limit is reached, caching another client first
+// evicts and closes the least recently used one. This guards against leaking
+// connection pools when a bug causes clients for ever new URLs to be
+// requested. 0, the default, means no limit. Lowering the limit doesn't evict
+// any clients until the next one is cached.
+//
+// Evicted clients are closed even if they are still in use, including by
+// ManagedClients, so n should comfortably exceed the number of clients that
+// are in use at any one time.
+func SetMaxCachedClients(n int) {
+	rcsMutex.Lock()
+	maxCachedClients = n
+	rcsMutex.Unlock()
+}
+
+// touch marks cc as just used. rcsMutex must be held.
+func (cc *cachedClient) touch() {
+	useCounter++
+	cc.lastUsed = useCounter
+}
+
+// evictLeastRecentlyUsed removes the least recently used client from the cache
+// if the cache is full, returning it so that the caller can close it outside of
+// the lock. rcsMutex must be held.
+func evictLeastRecentlyUsed() *cachedClient {
+	if maxCachedClients <= 0 || len(rcs) < maxCachedClients {
+		return nil
+	}
+	var oldestKey string
+	var oldest *cachedClient
+	for key, cc := range rcs {
+		if oldest == nil || cc.lastUsed < oldest.lastUsed {
+			oldestKey, oldest = key, cc
+		}
+	}
+	delete(rcs, oldestKey)
+	return oldest
+}
@@ -0,0 +1,47 @@
+package tlsredis
+
+import (
+	"testing"
+
+	"gopkg.in/redis.v5"
+)
+
+func TestSetMaxCachedClients(t *testing.T) {
+	SetMaxCachedClients(2)
+	t.Cleanup(func() { SetMaxCachedClients(0) })
+
+	var servers []*fakeRedis
+	var clients []*redis.Client
+	for i := 0; i < 3; i++ {
+		srv := startFakeRedis(t, nil)
+		closeClientOnCleanup(t, srv.url())
+		servers = append(servers, srv)
+	}
+	for _, srv := range servers[:2] {
+		rc, err := GetClient(&Options{RedisURL: srv.url()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, rc)
+	}
+	// Using the first client again makes the second the least recently used
+	if rc, _ := GetClient(&Options{RedisURL: servers[0].url()}); rc != clients[0] {
+		t.Fatal("Expected the first client to still be cached")
+	}
+	if _, err := GetClient(&Options{RedisURL: servers[2].url()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(CachedURLs()); n != 2 {
+		t.Errorf("Expected 2 cached clients, got %d", n)
+	}
+	if err := clients[1].Ping().Err(); err == nil {
+		t.Error("Expected the least recently used client to have been closed")
+	}
+	if err := clients[0].Ping().Err(); err != nil {
+		t.Errorf("Expected the recently used client to remain open: %v", err)
+	}
+	if rc, _ := GetClient(&Options{RedisURL: servers[1].url()}); rc == clients[1] {
+		t.Error("Expected the evicted client to have been removed from the cache")
+	}
+}
@@ -0,0 +1,72 @@
+package tlsredis
+
+import (
+	"sync"
+
+	"gopkg.in/redis.v5"
+)
+
+// ManagedClient is a cached client whose Close takes the cache into account.
+// Each call to GetManagedClient for the same cache entry counts as a reference,
+// and only closing the last one actually closes the client and evicts it from
+// the cache, so that GetClient never hands out a closed client afterwards.
+// References obtained through plain GetClient aren't counted, so closing a
+// ManagedClient still breaks those.
+type ManagedClient struct {
+	*redis.Client
+	key       string
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// GetManagedClient is like GetClient but returns a ManagedClient.
+func GetManagedClient(opts *Options) (*ManagedClient, error) {
+	if opts == nil {
+		return nil, ErrNilOptions
+	}
+	u, err := parseURL(opts.RedisURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	db := opts.db(u)
+	key := cacheKey(opts, u, db)
+	for {
+		rc, err := getClient(opts, u, db)
+		if err != nil {
+			return nil, err
+		}
+		rcsMutex.Lock()
+		cc := rcs[key]
+		if cc != nil && cc.client == rc {
+			cc.refs++
+			rcsMutex.Unlock()
+			return &ManagedClient{Client: rc, key: key}, nil
+		}
+		// The client was replaced or evicted in the meantime, try again
+		rcsMutex.Unlock()
+	}
+}
+
+// Close releases this reference to the client, closing the client and evicting
+// it from the cache if it was the last one. Closing a ManagedClient more than
+// once has no further effect.
+func (mc *ManagedClient) Close() error {
+	mc.closeOnce.Do(func() {
+		rcsMutex.Lock()
+		cc := rcs[mc.key]
+		if cc == nil || cc.client != mc.Client {
+			// Whatever removed the client from the cache already closed it
+			rcsMutex.Unlock()
+			return
+		}
+		cc.refs--
+		if cc.refs > 0 {
+			rcsMutex.Unlock()
+			return
+		}
+		delete(rcs, mc.key)
+		rcsMutex.Unlock()
+		mc.closeErr = mc.Client.Close()
+	})
+	return mc.closeErr
+}
@@ -0,0 +1,52 @@
+package tlsredis
+
+import (
+	"testing"
+)
+
+func TestManagedClient(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	opts := &Options{RedisURL: srv.url()}
+
+	first, err := GetManagedClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := GetManagedClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Client != second.Client {
+		t.Fatal("Expected managed clients to share the cached client")
+	}
+
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Closing again doesn't release the second reference
+	first.Close()
+	if err := second.Ping().Err(); err != nil {
+		t.Fatalf("Expected the client to stay open while referenced: %v", err)
+	}
+	if rc, _ := GetClient(opts); rc != second.Client {
+		t.Error("Expected the client to stay cached while referenced")
+	}
+
+	if err := second.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Ping().Err(); err == nil {
+		t.Error("Expected closing the last reference to close the client")
+	}
+	rc, err := GetClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc == second.Client {
+		t.Fatal("Expected GetClient to build a new client rather than return the closed one")
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+}
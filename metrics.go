@@ -0,0 +1,90 @@
+package tlsredis
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	metrics      Metrics = noopMetrics{}
+	metricsMutex sync.RWMutex
+)
+
+// Metrics receives metrics about this package's operation. Install an
+// implementation with SetMetrics.
+type Metrics interface {
+	// IncCacheHit is called whenever GetClient returns an existing client for
+	// host from the cache.
+	IncCacheHit(host string)
+
+	// IncCacheMiss is called whenever GetClient has to create a new client for
+	// host because there's no usable one in the cache.
+	IncCacheMiss(host string)
+}
+
+// ConnectLatencyObserver may optionally be implemented by a Metrics to find out
+// how long new clients take to connect.
+type ConnectLatencyObserver interface {
+	// ObserveConnectLatency is called once for each new client, when its first
+	// connection to host is ready for use. d is the time since the client
+	// started being created, covering any failed dials and retries, the TLS
+	// handshake, authentication and other connection setup. With
+	// VerifyOnConnect, that first connection is the one used to verify the
+	// client. attempts is the number of dials it took, including the successful
+	// one. It isn't called for clients that use DisableCustomDialer.
+	ObserveConnectLatency(host string, d time.Duration, attempts int)
+}
+
+// SetMetrics installs m to receive metrics. Passing nil stops reporting
+// metrics.
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = noopMetrics{}
+	}
+	metricsMutex.Lock()
+	metrics = m
+	metricsMutex.Unlock()
+}
+
+func getMetrics() Metrics {
+	metricsMutex.RLock()
+	defer metricsMutex.RUnlock()
+	return metrics
+}
+
+// connectTracker measures how long it takes for a new client to make its first
+// connection, for ConnectLatencyObserver.
+type connectTracker struct {
+	host     string
+	start    time.Time
+	attempts int32
+	once     sync.Once
+}
+
+// attempt records a dial attempt.
+func (tracker *connectTracker) attempt() {
+	atomic.AddInt32(&tracker.attempts, 1)
+}
+
+// wrap wraps dial so that the first successful connection is reported to the
+// installed Metrics if it's a ConnectLatencyObserver.
+func (tracker *connectTracker) wrap(dial func() (net.Conn, error)) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		conn, err := dial()
+		if err == nil {
+			tracker.once.Do(func() {
+				if observer, ok := getMetrics().(ConnectLatencyObserver); ok {
+					observer.ObserveConnectLatency(tracker.host, now().Sub(tracker.start), int(atomic.LoadInt32(&tracker.attempts)))
+				}
+			})
+		}
+		return conn, err
+	}
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) IncCacheHit(host string)  {}
+func (noopMetrics) IncCacheMiss(host string) {}
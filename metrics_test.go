@@ -0,0 +1,150 @@
+package tlsredis
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeMetrics counts the metrics it receives by host.
+type fakeMetrics struct {
+	mx     sync.Mutex
+	hits   map[string]int
+	misses map[string]int
+}
+
+// installFakeMetrics installs a new fakeMetrics until the test finishes.
+func installFakeMetrics(t *testing.T) *fakeMetrics {
+	m := &fakeMetrics{hits: make(map[string]int), misses: make(map[string]int)}
+	SetMetrics(m)
+	t.Cleanup(func() { SetMetrics(nil) })
+	return m
+}
+
+func (m *fakeMetrics) IncCacheHit(host string) {
+	m.mx.Lock()
+	m.hits[host]++
+	m.mx.Unlock()
+}
+
+func (m *fakeMetrics) IncCacheMiss(host string) {
+	m.mx.Lock()
+	m.misses[host]++
+	m.mx.Unlock()
+}
+
+func (m *fakeMetrics) counts(host string) (hits int, misses int) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.hits[host], m.misses[host]
+}
+
+func TestCacheMetrics(t *testing.T) {
+	m := installFakeMetrics(t)
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	opts := &Options{RedisURL: srv.url()}
+
+	if _, err := GetClient(opts); err != nil {
+		t.Fatal(err)
+	}
+	if hits, misses := m.counts(srv.addr); hits != 0 || misses != 1 {
+		t.Errorf("Expected a miss for the first call, got %d hits and %d misses", hits, misses)
+	}
+	if _, err := GetClient(opts); err != nil {
+		t.Fatal(err)
+	}
+	if hits, misses := m.counts(srv.addr); hits != 1 || misses != 1 {
+		t.Errorf("Expected a hit for the second call, got %d hits and %d misses", hits, misses)
+	}
+}
+
+// latencyMetrics is a fakeMetrics that also records connect latencies.
+type latencyMetrics struct {
+	*fakeMetrics
+	latencies []connectLatency
+}
+
+type connectLatency struct {
+	host     string
+	d        time.Duration
+	attempts int
+}
+
+func (m *latencyMetrics) ObserveConnectLatency(host string, d time.Duration, attempts int) {
+	m.mx.Lock()
+	m.latencies = append(m.latencies, connectLatency{host, d, attempts})
+	m.mx.Unlock()
+}
+
+func (m *latencyMetrics) observed() []connectLatency {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return append([]connectLatency(nil), m.latencies...)
+}
+
+// flakyListener closes the first failures connections it accepts straight
+// away.
+type flakyListener struct {
+	net.Listener
+	failures int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil || atomic.AddInt32(&l.failures, -1) < 0 {
+			return conn, err
+		}
+		conn.Close()
+	}
+}
+
+func TestObserveConnectLatency(t *testing.T) {
+	m := &latencyMetrics{fakeMetrics: installFakeMetrics(t)}
+	SetMetrics(m)
+	ca := newTestCA(t, "Test CA")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first two TLS handshakes fail, the third succeeds
+	srv := serveTestListener(t, tls.NewListener(&flakyListener{Listener: l, failures: 2}, serverTLSConfig(newServerCert(t, ca))))
+	srv.tls = true
+	closeClientOnCleanup(t, srv.url())
+
+	rc, err := GetClient(&Options{
+		RedisURL:         srv.url(),
+		RedisCAFile:      writeTestFile(t, "ca.pem", ca.certPEM()),
+		MaxDialRetries:   5,
+		DialRetryBackoff: 10 * time.Millisecond,
+		IsRetryable:      func(error) bool { return true },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	observed := m.observed()
+	if len(observed) != 1 {
+		t.Fatalf("Expected one observation, got %v", observed)
+	}
+	if observed[0].host != srv.addr || observed[0].attempts != 3 || observed[0].d <= 0 {
+		t.Errorf("Expected %v to take 3 attempts in a positive time, got %+v", srv.addr, observed[0])
+	}
+
+	// Later connections of the same client aren't reported
+	srv.dropConns()
+	// The first ping may fail on the dropped connection
+	rc.Ping()
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if observed := m.observed(); len(observed) != 1 {
+		t.Errorf("Expected reconnects not to be observed, got %v", observed)
+	}
+}
@@ -0,0 +1,127 @@
+package tlsredis
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"gopkg.in/redis.v5"
+)
+
+// onConnectFunc combines PasswordProvider, the HELLO for ProtocolVersion, the
+// CLIENT SETINFO for LibName and LibVer, StartupCommands and OnConnect from
+// opts into a single function to run on each new connection, or returns nil if
+// there's nothing to run. CLIENT SETINFO alone only warrants that if LibName or
+// LibVer is set explicitly. The function starts by authenticating and selecting
+// the database, since the commands that follow may depend on that.
+func onConnectFunc(opts *Options) func(*redis.Client) error {
+	password, passwordProvider, db := opts.Password, opts.PasswordProvider, opts.DB
+	if opts.SkipAuth {
+		password, passwordProvider = "", nil
+	}
+	authOpts := &Options{PasswordProvider: passwordProvider}
+	protocolVersion, startupCommands, onConnect := opts.ProtocolVersion, opts.StartupCommands, opts.OnConnect
+	var setInfo [][]interface{}
+	if !opts.DisableClientSetInfo {
+		libName := opts.LibName
+		if libName == "" {
+			libName = "tlsredis"
+		}
+		setInfo = append(setInfo, []interface{}{"CLIENT", "SETINFO", "lib-name", libName})
+		if opts.LibVer != "" {
+			setInfo = append(setInfo, []interface{}{"CLIENT", "SETINFO", "lib-ver", opts.LibVer})
+		}
+	}
+	explicitSetInfo := len(setInfo) > 0 && (opts.LibName != "" || opts.LibVer != "")
+	if passwordProvider == nil && protocolVersion == 0 && !explicitSetInfo && len(startupCommands) == 0 && onConnect == nil {
+		return nil
+	}
+	return func(rc *redis.Client) error {
+		password := password
+		if passwordProvider != nil {
+			var err error
+			password, err = resolvePassword(authOpts, nil)
+			if err != nil {
+				return err
+			}
+		}
+		if password != "" {
+			if err := rc.Process(redis.NewStatusCmd("AUTH", password)); err != nil {
+				return fmt.Errorf("Unable to authenticate: %v", err)
+			}
+		}
+		if db > 0 {
+			if err := rc.Process(redis.NewStatusCmd("SELECT", db)); err != nil {
+				return fmt.Errorf("Unable to select database %d: %v", db, err)
+			}
+		}
+		if protocolVersion != 0 {
+			cmd := redis.NewCmd("HELLO", protocolVersion)
+			if err := rc.Process(cmd); err != nil {
+				if !strings.Contains(strings.ToLower(err.Error()), "unknown command") {
+					return fmt.Errorf("Unable to select protocol version %d: %v", protocolVersion, err)
+				}
+				// Servers before Redis 6 only speak RESP2 anyway
+				log.Debugf("Server doesn't support HELLO, assuming protocol version 2")
+			}
+		}
+		for _, args := range setInfo {
+			if err := rc.Process(redis.NewStatusCmd(args...)); err != nil {
+				// Servers before Redis 7.2 don't know CLIENT SETINFO
+				log.Debugf("Unable to %v: %v", args, err)
+			}
+		}
+		for _, args := range startupCommands {
+			cmd := redis.NewCmd(args...)
+			if err := rc.Process(cmd); err != nil {
+				return fmt.Errorf("Startup command %v failed: %v", args, err)
+			}
+		}
+		if onConnect != nil {
+			return onConnect(rc)
+		}
+		return nil
+	}
+}
+
+// withOnConnect wraps dial so that onConnect gets run on every new connection
+// before it's handed to redis. redis.v5 has no hook for this, so onConnect is
+// given a single-connection client of its own that uses the new connection.
+// The pooled client then authenticates and selects the database once more as
+// usual, before the connection's first command.
+func withOnConnect(dial func() (net.Conn, error), redisOpts redis.Options, onConnect func(*redis.Client) error) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		conn, err := dial()
+		if err != nil {
+			return nil, err
+		}
+
+		rc := redis.NewClient(&redis.Options{
+			Dialer: func() (net.Conn, error) {
+				return &noCloseConn{conn}, nil
+			},
+			ReadTimeout:  redisOpts.ReadTimeout,
+			WriteTimeout: redisOpts.WriteTimeout,
+			PoolSize:     1,
+			// Disable the idle connection reaper
+			IdleCheckFrequency: -1,
+		})
+		err = onConnect(rc)
+		rc.Close()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// noCloseConn is a net.Conn that ignores Close, so that the temporary client
+// used by withOnConnect can be closed without closing the connection.
+type noCloseConn struct {
+	net.Conn
+}
+
+func (conn *noCloseConn) Close() error {
+	return nil
+}
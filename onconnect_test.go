@@ -0,0 +1,238 @@
+package tlsredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStartupCommands(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	rc, err := GetClient(&Options{
+		RedisURL: srv.url(),
+		StartupCommands: [][]interface{}{
+			{"CLIENT", "SETNAME", "worker"},
+			{"CONFIG", "SET", "timeout", 30},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	names := strings.Join(srv.recordedNames(), " ")
+	if !strings.Contains(names, "CLIENT CONFIG PING") {
+		t.Errorf("Expected the startup commands to run in order before PING, got %v", names)
+	}
+	if !srv.received("CLIENT", "SETNAME", "worker") || !srv.received("CONFIG", "SET", "timeout", "30") {
+		t.Errorf("Expected the startup commands with their arguments, got %v", srv.recorded())
+	}
+
+	failing := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, failing.url())
+	failing.setReply(func(args []string) string {
+		if args[0] == "CONFIG" {
+			return "-ERR CONFIG is disabled\r\n"
+		}
+		return ""
+	})
+	rc, err = GetClient(&Options{RedisURL: failing.url(), StartupCommands: [][]interface{}{{"CONFIG", "SET", "timeout", 30}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rc.Ping().Err()
+	if err == nil || !strings.Contains(err.Error(), "CONFIG is disabled") {
+		t.Errorf("Expected the failing startup command to fail the connection, got %v", err)
+	}
+	if failing.received("PING") {
+		t.Error("Expected the connection to be abandoned after the failing startup command")
+	}
+}
+
+func TestProtocolVersion(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	srv.setReply(func(args []string) string {
+		if args[0] == "HELLO" {
+			return "*2\r\n" + bulkString("proto") + ":2\r\n"
+		}
+		return ""
+	})
+	rc, err := GetClient(&Options{RedisURL: srv.url(), ProtocolVersion: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.received("HELLO", "2") {
+		t.Errorf("Expected HELLO with the configured version, got %v", srv.recorded())
+	}
+
+	// Servers before Redis 6 don't know HELLO
+	old := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, old.url())
+	old.setReply(func(args []string) string {
+		if args[0] == "HELLO" {
+			return "-ERR unknown command 'HELLO'\r\n"
+		}
+		return ""
+	})
+	rc, err = GetClient(&Options{RedisURL: old.url(), ProtocolVersion: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Errorf("Expected to fall back gracefully on servers without HELLO: %v", err)
+	}
+
+	for _, version := range []int{3, 4} {
+		if _, err := GetClientContext(context.Background(), &Options{RedisURL: srv.url(), ProtocolVersion: version}); err == nil {
+			t.Errorf("Expected ProtocolVersion %d to be rejected", version)
+		}
+	}
+}
+
+func TestPasswordProvider(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	var mx sync.Mutex
+	password := "first"
+	opts := &Options{
+		RedisURL: srv.url(),
+		PasswordProvider: func() (string, error) {
+			mx.Lock()
+			defer mx.Unlock()
+			if password == "" {
+				return "", errors.New("vault is sealed")
+			}
+			return password, nil
+		},
+	}
+	opts.Password = "static"
+	opts.IdleTimeout = 100 * time.Millisecond
+	opts.IdleCheckFrequency = 20 * time.Millisecond
+	rotate := func(newPassword string) {
+		mx.Lock()
+		password = newPassword
+		mx.Unlock()
+	}
+
+	rc, err := GetClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.received("AUTH", "first") || srv.received("AUTH", "static") {
+		t.Fatalf("Expected to authenticate with the provided password, got %v", srv.recorded())
+	}
+
+	// The existing connection keeps working after the rotation...
+	rotate("second")
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if srv.received("AUTH", "second") {
+		t.Error("Expected the existing connection to be reused without authenticating again")
+	}
+	// ...until it's recycled, after which the new password is used
+	time.Sleep(300 * time.Millisecond)
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.received("AUTH", "second") {
+		t.Errorf("Expected the new connection to use the rotated password, got %v", srv.recorded())
+	}
+
+	rotate("")
+	srv.dropConns()
+	time.Sleep(300 * time.Millisecond)
+	if err := rc.Ping().Err(); err == nil || !strings.Contains(err.Error(), "vault is sealed") {
+		t.Errorf("Expected a failing PasswordProvider to fail the connection, got %v", err)
+	}
+}
+
+func TestClientSetInfo(t *testing.T) {
+	// Without anything else to run on new connections, redis authenticates and
+	// selects the database by itself and CLIENT SETINFO isn't sent
+	plain := startFakeRedis(t, nil)
+	plainURL := "redis://:s3cret@" + plain.addr + "/4"
+	closeClientOnCleanup(t, plainURL)
+	rc, err := GetClient(&Options{RedisURL: plainURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if recorded := plain.recorded(); fmt.Sprint(recorded) != fmt.Sprint([][]string{{"AUTH", "s3cret"}, {"SELECT", "4"}, {"PING"}}) {
+		t.Errorf("Expected just AUTH, SELECT and PING by default, got %v", recorded)
+	}
+
+	// Commands run on new connections anyway come with the default LibName,
+	// after authenticating and selecting the database
+	srv := startFakeRedis(t, nil)
+	redisURL := "redis://:s3cret@" + srv.addr + "/4"
+	closeClientOnCleanup(t, redisURL)
+	rc, err = GetClient(&Options{RedisURL: redisURL, StartupCommands: [][]interface{}{{"CLIENT", "SETNAME", "worker"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	recorded := srv.recorded()
+	expected := [][]string{
+		{"AUTH", "s3cret"},
+		{"SELECT", "4"},
+		{"CLIENT", "SETINFO", "lib-name", "tlsredis"},
+		{"CLIENT", "SETNAME", "worker"},
+	}
+	if len(recorded) < len(expected) || fmt.Sprint(recorded[:len(expected)]) != fmt.Sprint(expected) {
+		t.Errorf("Expected AUTH and SELECT before CLIENT SETINFO, got %v", recorded)
+	}
+	if last := recorded[len(recorded)-1]; last[0] != "PING" {
+		t.Errorf("Expected PING last, got %v", recorded)
+	}
+
+	// Older servers reject CLIENT SETINFO, which doesn't fail the connection
+	older := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, older.url())
+	older.setReply(func(args []string) string {
+		if args[0] == "CLIENT" {
+			return "-ERR unknown subcommand 'SETINFO'\r\n"
+		}
+		return ""
+	})
+	rc, err = GetClient(&Options{RedisURL: older.url(), LibName: "billing", LibVer: "1.2.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatalf("Expected a rejected CLIENT SETINFO to be ignored: %v", err)
+	}
+	if !older.received("CLIENT", "SETINFO", "lib-name", "billing") || !older.received("CLIENT", "SETINFO", "lib-ver", "1.2.3") {
+		t.Errorf("Expected the configured LibName and LibVer, got %v", older.recorded())
+	}
+
+	disabled := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, disabled.url())
+	rc, err = GetClient(&Options{RedisURL: disabled.url(), DisableClientSetInfo: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if names := disabled.recordedNames(); len(names) != 1 || names[0] != "PING" {
+		t.Errorf("Expected only PING with DisableClientSetInfo, got %v", names)
+	}
+}
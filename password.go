@@ -0,0 +1,52 @@
+package tlsredis
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// resolvePassword determines the password to authenticate with for u. It's the
+// first of these that's set, from highest to lowest precedence:
+//
+//  1. PasswordProvider
+//  2. PasswordFile
+//  3. PasswordEnv
+//  4. Password
+//  5. the password in the userinfo of u
+//
+// An empty password means not to authenticate, as does SkipAuth. u may be nil
+// if it has no userinfo.
+func resolvePassword(opts *Options, u *url.URL) (string, error) {
+	switch {
+	case opts.SkipAuth:
+		return "", nil
+	case opts.PasswordProvider != nil:
+		password, err := opts.PasswordProvider()
+		if err != nil {
+			return "", fmt.Errorf("Unable to get password: %v", err)
+		}
+		return password, nil
+	case opts.PasswordFile != "":
+		passwordFile := opts.expandPath(opts.PasswordFile)
+		b, err := ioutil.ReadFile(passwordFile)
+		if err != nil {
+			return "", fmt.Errorf("Unable to read PasswordFile %v: %v", passwordFile, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case opts.PasswordEnv != "":
+		password, found := os.LookupEnv(opts.PasswordEnv)
+		if !found {
+			return "", fmt.Errorf("PasswordEnv %v is not set", opts.PasswordEnv)
+		}
+		return password, nil
+	case opts.Password != "":
+		return opts.Password, nil
+	case u != nil && u.User != nil:
+		password, _ := u.User.Password()
+		return password, nil
+	}
+	return "", nil
+}
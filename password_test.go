@@ -0,0 +1,64 @@
+package tlsredis
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestResolvePassword(t *testing.T) {
+	passwordFile := writeTestFile(t, "password", []byte("from-file\n"))
+	t.Setenv("TLSREDIS_TEST_PASSWORD", "from-env")
+
+	// Every combination of sources, from highest to lowest precedence
+	sources := []string{"from-provider", "from-file", "from-env", "from-field", "from-url"}
+	for combination := 0; combination < 1<<len(sources); combination++ {
+		opts := &Options{}
+		var u *url.URL
+		expected := ""
+		for i := len(sources) - 1; i >= 0; i-- {
+			if combination&(1<<i) == 0 {
+				continue
+			}
+			expected = sources[i]
+			switch i {
+			case 0:
+				opts.PasswordProvider = func() (string, error) { return "from-provider", nil }
+			case 1:
+				opts.PasswordFile = passwordFile
+			case 2:
+				opts.PasswordEnv = "TLSREDIS_TEST_PASSWORD"
+			case 3:
+				opts.Password = "from-field"
+			case 4:
+				u = &url.URL{User: url.UserPassword("user", "from-url")}
+			}
+		}
+		password, err := resolvePassword(opts, u)
+		if err != nil {
+			t.Fatalf("Combination %05b: %v", combination, err)
+		}
+		if password != expected {
+			t.Errorf("Combination %05b: expected %q, got %q", combination, expected, password)
+		}
+
+		opts.SkipAuth = true
+		if password, err := resolvePassword(opts, u); err != nil || password != "" {
+			t.Errorf("Combination %05b: expected no password with SkipAuth, got %q (%v)", combination, password, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		opts     *Options
+		expected string
+	}{
+		{&Options{PasswordProvider: func() (string, error) { return "", errors.New("vault is sealed") }}, "Unable to get password: vault is sealed"},
+		{&Options{PasswordFile: passwordFile + ".missing"}, "Unable to read PasswordFile"},
+		{&Options{PasswordEnv: "TLSREDIS_TEST_UNSET_PASSWORD"}, "PasswordEnv TLSREDIS_TEST_UNSET_PASSWORD is not set"},
+	} {
+		if _, err := resolvePassword(tc.opts, nil); err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("Expected an error containing %q, got %v", tc.expected, err)
+		}
+	}
+}
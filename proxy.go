@@ -0,0 +1,150 @@
+package tlsredis
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ProxyTLSConfig configures the TLS connection to an https ProxyURL. It's
+// independent of the TLS connection to Redis that's layered on top of the
+// tunnel, which is configured by the rest of Options as usual.
+type ProxyTLSConfig struct {
+	// ServerName is the SNI to send to the proxy. Defaults to the host of
+	// ProxyURL.
+	ServerName string
+
+	// VerifyServerName, if set, is the name that the proxy's certificate is
+	// verified against instead of ServerName.
+	VerifyServerName string
+
+	// RootCAFile, if set, is a PEM file of CA certificates to verify the
+	// proxy's certificate against instead of the system roots.
+	RootCAFile string
+
+	// InsecureSkipVerify, if true, skips verifying the proxy's certificate.
+	InsecureSkipVerify bool
+
+	// VerifyConnection, if set, is called after the handshake with the proxy
+	// and the usual verification, and fails the connection if it returns an
+	// error. It's never marshalled to JSON.
+	VerifyConnection func(tls.ConnectionState) error `json:"-"`
+}
+
+// proxyTLSConfig builds the tls.Config for connecting to the https proxy at
+// proxyURL according to cfg, which may be nil.
+func proxyTLSConfig(proxyURL *url.URL, cfg *ProxyTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: proxyURL.Hostname()}
+	if cfg == nil {
+		return tlsConfig, nil
+	}
+	if cfg.ServerName != "" {
+		tlsConfig.ServerName = cfg.ServerName
+	}
+	if cfg.RootCAFile != "" {
+		pool, err := loadCAFile(cfg.RootCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load proxy RootCAFile: %v", err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.InsecureSkipVerify {
+		log.Errorf("Not verifying proxy certificate, connection to proxy is vulnerable to man-in-the-middle attacks")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if cfg.VerifyServerName != "" {
+		log.Debugf("Sending SNI %v to proxy but verifying its certificate for %v", tlsConfig.ServerName, cfg.VerifyServerName)
+		verifyServerNames(tlsConfig, []string{cfg.VerifyServerName})
+	}
+	if cfg.VerifyConnection != nil {
+		addVerifier(tlsConfig, cfg.VerifyConnection)
+	}
+	return tlsConfig, nil
+}
+
+// dialViaProxy connects to addr through a CONNECT tunnel on the HTTP proxy at
+// proxyURL, which it reaches using dial. For https proxies, proxyTLS is used
+// for the TLS connection to the proxy. The whole exchange must complete within
+// timeout.
+func dialViaProxy(ctx context.Context, dial func(network string, addr string) (net.Conn, error), timeout time.Duration, proxyURL *url.URL, proxyTLS *tls.Config, addr string) (net.Conn, error) {
+	deadline := time.Now().Add(timeout)
+
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	conn, err := dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to proxy at %v: %v", proxyAddr, err)
+	}
+	if proxyURL.Scheme == "https" {
+		conn, err = handshake(ctx, conn, proxyTLS, deadline)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to establish TLS with proxy at %v: %v", proxyAddr, err)
+		}
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Unable to send CONNECT to proxy at %v: %v", proxyAddr, err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Unable to read CONNECT response from proxy at %v: %v", proxyAddr, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("Proxy at %v refused CONNECT to %v: %v", proxyAddr, addr, resp.Status)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if br.Buffered() > 0 {
+		// The proxy already sent us some data from the tunnel, don't lose it
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose reads are satisfied from a bufio.Reader
+// wrapping it.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(b []byte) (int, error) {
+	return conn.r.Read(b)
+}
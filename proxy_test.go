@@ -0,0 +1,217 @@
+package tlsredis
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// connectProxy is an HTTP proxy that only supports CONNECT, to addresses it's
+// been told to allow.
+type connectProxy struct {
+	addr     string
+	listener net.Listener
+
+	mx      sync.Mutex
+	allowed map[string]bool
+	auth    []string
+}
+
+// startConnectProxy starts a connectProxy allowing tunnels to the given
+// addresses, speaking TLS if tlsConfig is given. It's stopped when the test
+// finishes.
+func startConnectProxy(t *testing.T, tlsConfig *tls.Config, allowed ...string) *connectProxy {
+	t.Helper()
+	var l net.Listener
+	var err error
+	if tlsConfig != nil {
+		l, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &connectProxy{addr: l.Addr().String(), listener: l, allowed: make(map[string]bool)}
+	for _, addr := range allowed {
+		proxy.allowed[addr] = true
+	}
+	go proxy.serve()
+	t.Cleanup(func() { l.Close() })
+	return proxy
+}
+
+// authorizations returns the Proxy-Authorization headers received so far.
+func (proxy *connectProxy) authorizations() []string {
+	proxy.mx.Lock()
+	defer proxy.mx.Unlock()
+	return append([]string(nil), proxy.auth...)
+}
+
+func (proxy *connectProxy) serve() {
+	for {
+		conn, err := proxy.listener.Accept()
+		if err != nil {
+			return
+		}
+		go proxy.serveConn(conn)
+	}
+}
+
+func (proxy *connectProxy) serveConn(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return
+	}
+	proxy.mx.Lock()
+	proxy.auth = append(proxy.auth, req.Header.Get("Proxy-Authorization"))
+	allowed := proxy.allowed[req.Host]
+	proxy.mx.Unlock()
+
+	if req.Method != http.MethodConnect || !allowed {
+		io.WriteString(conn, "HTTP/1.1 403 Forbidden\r\n\r\n")
+		return
+	}
+	upstream, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer upstream.Close()
+	io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n")
+	go io.Copy(upstream, br)
+	io.Copy(conn, upstream)
+}
+
+func TestProxyURL(t *testing.T) {
+	srv, caFile := startTLSFakeRedis(t)
+	closeClientOnCleanup(t, srv.url())
+	proxy := startConnectProxy(t, nil, srv.addr)
+
+	rc, err := GetClient(&Options{RedisURL: srv.url(), RedisCAFile: caFile, ProxyURL: "http://user:secret@" + proxy.addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatalf("Expected to reach Redis through the proxy: %v", err)
+	}
+	if auth := proxy.authorizations(); len(auth) == 0 || auth[0] != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("Expected basic proxy authorization, got %v", auth)
+	}
+
+	dial, err := BuildDialer(&Options{RedisURL: "rediss://127.0.0.1:1", ProxyURL: "http://" + proxy.addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dial()
+	if err == nil || !strings.Contains(err.Error(), "refused CONNECT to 127.0.0.1:1: 403 Forbidden") {
+		t.Errorf("Expected an error describing the refused CONNECT, got %v", err)
+	}
+}
+
+func TestProxyTLS(t *testing.T) {
+	// Redis only has a certificate for its internal name
+	redisCA := newTestCA(t, "Redis CA")
+	srv := startFakeRedis(t, serverTLSConfig(issueCert(t, redisCA, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "redis"},
+		DNSNames:    []string{"redis.internal"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})))
+	redisCAFile := writeTestFile(t, "redis-ca.pem", redisCA.certPEM())
+
+	// The proxy routes by SNI and has a certificate from another CA
+	proxyCA := newTestCA(t, "Proxy CA")
+	proxyCert := issueCert(t, proxyCA, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "egress"},
+		DNSNames:    []string{"redis-route.egress.test"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}).tlsCertificate()
+	var mx sync.Mutex
+	var sni []string
+	proxy := startConnectProxy(t, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			mx.Lock()
+			sni = append(sni, hello.ServerName)
+			mx.Unlock()
+			return &proxyCert, nil
+		},
+	}, srv.addr)
+	proxyCAFile := writeTestFile(t, "proxy-ca.pem", proxyCA.certPEM())
+
+	newOpts := func(proxyTLS *ProxyTLSConfig) *Options {
+		return &Options{
+			RedisURL:         srv.url(),
+			RedisCAFile:      redisCAFile,
+			VerifyServerName: "redis.internal",
+			ProxyURL:         "https://" + proxy.addr,
+			ProxyTLS:         proxyTLS,
+			DialTimeout:      time.Second,
+		}
+	}
+	dial, err := BuildDialer(newOpts(&ProxyTLSConfig{ServerName: "redis-route.egress.test", RootCAFile: proxyCAFile}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dial()
+	if err != nil {
+		t.Fatalf("Expected to reach Redis through the TLS proxy: %v", err)
+	}
+	pingConn(t, conn)
+	conn.Close()
+	mx.Lock()
+	if len(sni) != 1 || sni[0] != "redis-route.egress.test" {
+		t.Errorf("Expected the proxy to receive the configured SNI, got %v", sni)
+	}
+	mx.Unlock()
+
+	verifyErr := errors.New("proxy isn't the one we expected")
+	for _, tc := range []struct {
+		opts     *Options
+		expected string
+	}{
+		{newOpts(&ProxyTLSConfig{RootCAFile: proxyCAFile}), "Unable to establish TLS with proxy"},
+		{newOpts(&ProxyTLSConfig{ServerName: "redis-route.egress.test"}), "Unable to establish TLS with proxy"},
+		{newOpts(&ProxyTLSConfig{ServerName: "other.egress.test", RootCAFile: proxyCAFile, VerifyServerName: "redis-route.egress.test"}), ""},
+		{newOpts(&ProxyTLSConfig{ServerName: "redis-route.egress.test", RootCAFile: proxyCAFile, VerifyConnection: func(tls.ConnectionState) error { return verifyErr }}), verifyErr.Error()},
+	} {
+		dial, err := BuildDialer(tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dial()
+		if tc.expected == "" && err != nil {
+			t.Errorf("%+v: expected to connect, got %v", tc.opts.ProxyTLS, err)
+		} else if tc.expected != "" && (err == nil || !strings.Contains(err.Error(), tc.expected)) {
+			t.Errorf("%+v: expected an error containing %q, got %v", tc.opts.ProxyTLS, tc.expected, err)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+
+	// The inner verification is independent of the proxy's
+	opts := newOpts(&ProxyTLSConfig{ServerName: "redis-route.egress.test", RootCAFile: proxyCAFile})
+	opts.VerifyServerName = "redis-route.egress.test"
+	dial, err = BuildDialer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dial(); err == nil || !strings.Contains(err.Error(), "tls handshake") {
+		t.Errorf("Expected the inner handshake to fail for the wrong name, got %v", err)
+	}
+
+	if _, err := BuildDialer(&Options{RedisURL: srv.url(), ProxyURL: "http://" + proxy.addr, ProxyTLS: &ProxyTLSConfig{}}); err == nil {
+		t.Error("Expected ProxyTLS to require an https ProxyURL")
+	}
+}
@@ -0,0 +1,118 @@
+package tlsredis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// proxyProtocolV2Signature starts every PROXY protocol version 2 header.
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// withProxyProtocol wraps connect so that a PROXY protocol header of the given
+// version is sent on each new connection before anything else. src and dst
+// override the addresses reported in the header, which otherwise are the local
+// and remote addresses of the connection. Writing the header must complete
+// within timeout.
+func withProxyProtocol(connect func() (net.Conn, error), version int, src, dst *net.TCPAddr, timeout time.Duration) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		conn, err := connect()
+		if err != nil {
+			return nil, err
+		}
+		source, dest := src, dst
+		if source == nil {
+			source, _ = conn.LocalAddr().(*net.TCPAddr)
+		}
+		if dest == nil {
+			dest, _ = conn.RemoteAddr().(*net.TCPAddr)
+		}
+		header := proxyProtocolHeader(version, source, dest)
+		if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if _, err := conn.Write(header); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Unable to send PROXY protocol header: %v", err)
+		}
+		if err := conn.SetWriteDeadline(time.Time{}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// proxyProtocolHeader builds a PROXY protocol header for a TCP connection from
+// src to dst. If either address is unknown, or they're of different families,
+// the header says so and the receiver falls back to the actual addresses of
+// the connection.
+func proxyProtocolHeader(version int, src, dst *net.TCPAddr) []byte {
+	var srcIP, dstIP net.IP
+	known, ipv4 := false, false
+	if src != nil && dst != nil {
+		srcIP, dstIP = src.IP.To4(), dst.IP.To4()
+		ipv4 = srcIP != nil && dstIP != nil
+		if !ipv4 && srcIP == nil && dstIP == nil {
+			srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+		}
+		// Mixing IPv4 and IPv6 addresses can't be expressed in the header
+		known = ipv4 || (srcIP != nil && dstIP != nil && srcIP.To4() == nil && dstIP.To4() == nil)
+	}
+
+	if version == 2 {
+		var buf bytes.Buffer
+		buf.Write(proxyProtocolV2Signature)
+		if !known {
+			// Version 2, LOCAL command with unspecified family and no addresses
+			buf.Write([]byte{0x20, 0x00, 0x00, 0x00})
+			return buf.Bytes()
+		}
+		// Version 2, PROXY command, followed by the family over TCP
+		family, length := byte(0x21), uint16(36)
+		if ipv4 {
+			family, length = 0x11, 12
+		}
+		buf.Write([]byte{0x21, family})
+		binary.Write(&buf, binary.BigEndian, length)
+		buf.Write(srcIP)
+		buf.Write(dstIP)
+		binary.Write(&buf, binary.BigEndian, uint16(src.Port))
+		binary.Write(&buf, binary.BigEndian, uint16(dst.Port))
+		return buf.Bytes()
+	}
+
+	if !known {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	protocol := "TCP6"
+	if ipv4 {
+		protocol = "TCP4"
+	}
+	return []byte(fmt.Sprintf("PROXY %v %v %v %d %d\r\n", protocol, srcIP, dstIP, src.Port, dst.Port))
+}
+
+// parseProxyProtocolAddr parses addr, which must be an IP address and port, for
+// use in a PROXY protocol header. An empty addr yields nil.
+func parseProxyProtocolAddr(name string, addr string) (*net.TCPAddr, error) {
+	if addr == "" {
+		return nil, nil
+	}
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse %v: %v", name, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("%v must be an IP address and port, not %q", name, addr)
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid port in %v: %q", name, portString)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
@@ -0,0 +1,151 @@
+package tlsredis
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+// proxyProtocolListener is a net.Listener that reads and records the PROXY
+// protocol header at the start of each accepted connection.
+type proxyProtocolListener struct {
+	net.Listener
+	headers chan []byte
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	header, err := readProxyProtocolHeader(r)
+	if err != nil {
+		header = []byte(fmt.Sprintf("Bad header: %v", err))
+	}
+	l.headers <- header
+	return &bufferedConn{Conn: conn, r: r}, nil
+}
+
+// readProxyProtocolHeader reads a version 1 or 2 PROXY protocol header from r.
+func readProxyProtocolHeader(r *bufio.Reader) ([]byte, error) {
+	prefix, err := r.Peek(len(proxyProtocolV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(prefix, proxyProtocolV2Signature) {
+		line, err := r.ReadString('\n')
+		return []byte(line), err
+	}
+	header := make([]byte, len(proxyProtocolV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	addrs := make([]byte, binary.BigEndian.Uint16(header[len(header)-2:]))
+	if _, err := io.ReadFull(r, addrs); err != nil {
+		return nil, err
+	}
+	return append(header, addrs...), nil
+}
+
+// startProxyProtocolRedis starts a fakeRedis behind a proxyProtocolListener,
+// speaking TLS if tlsConfig is given.
+func startProxyProtocolRedis(t *testing.T, tlsConfig *tls.Config) (*fakeRedis, chan []byte) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := make(chan []byte, 10)
+	var listener net.Listener = &proxyProtocolListener{Listener: l, headers: headers}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	srv := serveTestListener(t, listener)
+	srv.tls = tlsConfig != nil
+	return srv, headers
+}
+
+func TestSendProxyProtocol(t *testing.T) {
+	plaintext, plaintextHeaders := startProxyProtocolRedis(t, nil)
+	ca := newTestCA(t, "Test CA")
+	secure, secureHeaders := startProxyProtocolRedis(t, serverTLSConfig(newServerCert(t, ca)))
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM())
+
+	dial, err := BuildDialer(&Options{RedisURL: plaintext.url(), SendProxyProtocol: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingConn(t, conn)
+	local := conn.LocalAddr().(*net.TCPAddr)
+	conn.Close()
+	expected := fmt.Sprintf("PROXY TCP4 127.0.0.1 127.0.0.1 %d %v\r\n", local.Port, plaintext.listener.Addr().(*net.TCPAddr).Port)
+	if header := string(<-plaintextHeaders); header != expected {
+		t.Errorf("Expected header %q, got %q", expected, header)
+	}
+
+	// Version 2 with explicit addresses, sent before the TLS handshake
+	dial, err = BuildDialer(&Options{
+		RedisURL:                secure.url(),
+		RedisCAFile:             caFile,
+		SendProxyProtocol:       true,
+		ProxyProtocolVersion:    2,
+		ProxyProtocolSourceAddr: "203.0.113.7:40000",
+		ProxyProtocolDestAddr:   "10.0.0.1:6380",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err = dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingConn(t, conn)
+	conn.Close()
+	expectedV2 := append(append([]byte(nil), proxyProtocolV2Signature...),
+		0x21, 0x11, 0, 12,
+		203, 0, 113, 7,
+		10, 0, 0, 1,
+		0x9c, 0x40,
+		0x18, 0xec,
+	)
+	if header := <-secureHeaders; !bytes.Equal(header, expectedV2) {
+		t.Errorf("Expected header %x, got %x", expectedV2, header)
+	}
+
+	if _, err := BuildDialer(&Options{RedisURL: plaintext.url(), SendProxyProtocol: true, ProxyProtocolSourceAddr: "redis.internal:1"}); err == nil {
+		t.Error("Expected an error for a source address that isn't an IP")
+	}
+}
+
+func TestProxyProtocolHeader(t *testing.T) {
+	v4 := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+	v6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 6379}
+	for _, tc := range []struct {
+		src, dst *net.TCPAddr
+		expected string
+	}{
+		{v4, v4, "PROXY TCP4 192.0.2.1 192.0.2.1 1234 1234\r\n"},
+		{v6, v6, "PROXY TCP6 2001:db8::1 2001:db8::1 6379 6379\r\n"},
+		{v4, v6, "PROXY UNKNOWN\r\n"},
+		{nil, v4, "PROXY UNKNOWN\r\n"},
+	} {
+		if header := string(proxyProtocolHeader(1, tc.src, tc.dst)); header != tc.expected {
+			t.Errorf("%v -> %v: expected %q, got %q", tc.src, tc.dst, tc.expected, header)
+		}
+	}
+	if header := proxyProtocolHeader(2, v4, v6); !bytes.Equal(header, append(append([]byte(nil), proxyProtocolV2Signature...), 0x20, 0, 0, 0)) {
+		t.Errorf("Expected a LOCAL header for mixed families, got %x", header)
+	}
+	if header := proxyProtocolHeader(2, v6, v6); len(header) != len(proxyProtocolV2Signature)+4+36 || header[13] != 0x21 {
+		t.Errorf("Expected an IPv6 header, got %x", header)
+	}
+}
@@ -0,0 +1,32 @@
+package tlsredis
+
+import (
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// GetPubSubClient is like GetClient but returns a client meant for Subscribe
+// and PSubscribe. Unless configured otherwise in opts, it doesn't time out
+// reads, so that idle subscriptions aren't dropped, and uses a pool of just 2
+// connections. It's cached separately from the GetClient client for the same
+// URL.
+func GetPubSubClient(opts *Options) (*redis.Client, error) {
+	if opts == nil {
+		return nil, ErrNilOptions
+	}
+	pubSubOpts := *opts
+	pubSubOpts.pubSub = true
+	if pubSubOpts.ReadTimeout == 0 {
+		// -1 means no timeout to redis.v5
+		pubSubOpts.ReadTimeout = -1
+		if pubSubOpts.WriteTimeout == 0 {
+			// Otherwise this would default to ReadTimeout, i.e. no timeout
+			pubSubOpts.WriteTimeout = 3 * time.Second
+		}
+	}
+	if pubSubOpts.PoolSize == 0 {
+		pubSubOpts.PoolSize = 2
+	}
+	return GetClient(&pubSubOpts)
+}
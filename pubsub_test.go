@@ -0,0 +1,50 @@
+package tlsredis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetPubSubClient(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	opts := &Options{RedisURL: srv.url()}
+
+	rc, err := GetClient(&Options{RedisURL: srv.url()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubSub, err := GetPubSubClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pubSub == rc {
+		t.Fatal("Expected the pub/sub client to be separate from the regular one")
+	}
+	if again, _ := GetPubSubClient(opts); again != pubSub {
+		t.Error("Expected the pub/sub client to be cached")
+	}
+
+	if timeout := time.Duration(clientOption(pubSub, "ReadTimeout")); timeout != 0 {
+		t.Errorf("Expected no read timeout for the pub/sub client, got %v", timeout)
+	}
+	if timeout := time.Duration(clientOption(rc, "ReadTimeout")); timeout == 0 {
+		t.Error("Expected a read timeout for the regular client")
+	}
+	if size := clientOption(pubSub, "PoolSize"); size != 2 {
+		t.Errorf("Expected a pool of 2 for the pub/sub client, got %d", size)
+	}
+
+	subscription, err := pubSub.Subscribe("news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscription.Close()
+	// The command is sent without waiting for the reply
+	for deadline := time.Now().Add(time.Second); !srv.received("SUBSCRIBE", "news") && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if !srv.received("SUBSCRIBE", "news") {
+		t.Errorf("Expected to subscribe, got %v", srv.recorded())
+	}
+}
@@ -0,0 +1,136 @@
+package tlsredis
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+const (
+	// reloadDrainTimeout bounds how long Reload waits for commands in flight
+	// on the old client before closing it anyway.
+	reloadDrainTimeout = 10 * time.Second
+
+	reloadDrainPollInterval = 50 * time.Millisecond
+)
+
+// Reload replaces the cached client for redisURL with one built from newOpts
+// (whose RedisURL is ignored), e.g. to pick up a new CA or password when
+// reloading configuration. The new client is fully built and its connection
+// verified before it replaces the old one in the cache, so that GetClient keeps
+// returning a working client throughout. If that fails, the error is returned
+// and the old client stays cached and usable.
+//
+// Once replaced, the old client is closed in the background as soon as it has
+// no commands in flight, or after 10 seconds at the latest. Callers still
+// holding on to it should switch to the returned client.
+func Reload(redisURL string, newOpts *Options) (*redis.Client, error) {
+	u, err := parseURL(redisURL, newOpts)
+	if err != nil {
+		return nil, err
+	}
+	return reload(newOpts, u)
+}
+
+// reload implements Reload for the already parsed u.
+func reload(newOpts *Options, u *url.URL) (*redis.Client, error) {
+	db := newOpts.db(u)
+	key := cacheKey(newOpts, u, db)
+
+	var mtimes map[string]time.Time
+	if newOpts.WatchCertFiles {
+		mtimes = certMTimes(newOpts)
+	}
+	connState := &connStateHolder{}
+	rc, err := newClient(context.Background(), newOpts, u, db, connState)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyConnection(context.Background(), rc, newOpts, u.Host); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	rcsMutex.Lock()
+	old := rcs[key]
+	var evicted *cachedClient
+	if old == nil {
+		evicted = evictLeastRecentlyUsed()
+	}
+	cc := &cachedClient{client: rc, host: u.Host, url: endpointURL(u, db), mutualTLS: newOpts.hasClientCert(), connState: connState, certMTimes: mtimes}
+	cc.touch()
+	rcs[key] = cc
+	rcsMutex.Unlock()
+	log.Debugf("Reloaded client for %v", u.Host)
+
+	if newOpts.OnNewClient != nil {
+		newOpts.OnNewClient(rc)
+	}
+	if evicted != nil {
+		go drainAndClose(evicted.client, evicted.host)
+	}
+	if old != nil {
+		go drainAndClose(old.client, u.Host)
+	}
+	return rc, nil
+}
+
+// ReloadAll reloads every cached client like Reload does, e.g. during a fleet
+// wide TLS rollout. For each URL listed by CachedURLs, optsFor returns the new
+// options, or nil to leave that URL's client alone. If those options have a
+// RedisURL, the new client is cached for it instead, which allows switching
+// from redis to rediss, and all old clients for the URL are drained and closed.
+// Otherwise only the client that GetClient would return for the URL is
+// replaced. Failures don't stop the other URLs from being reloaded, and are
+// returned together.
+func ReloadAll(optsFor func(url string) *Options) error {
+	var failures []string
+	for _, cachedURL := range CachedURLs() {
+		newOpts := optsFor(cachedURL)
+		if newOpts == nil {
+			continue
+		}
+		target := cachedURL
+		if newOpts.RedisURL != "" {
+			target = newOpts.RedisURL
+		}
+		u, err := parseURL(target, newOpts)
+		if err == nil {
+			_, err = reload(newOpts, u)
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%v: %v", cachedURL, err))
+			continue
+		}
+		if newURL := endpointURL(u, newOpts.db(u)); newURL != cachedURL {
+			log.Debugf("Moved client for %v to %v", cachedURL, newURL)
+			for _, cc := range uncache(cachedURL) {
+				go drainAndClose(cc.client, cc.host)
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("Unable to reload %d client(s): %v", len(failures), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// drainAndClose closes rc once none of its pooled connections are in use, or
+// after reloadDrainTimeout.
+func drainAndClose(rc *redis.Client, host string) {
+	deadline := time.Now().Add(reloadDrainTimeout)
+	for time.Now().Before(deadline) {
+		stats := rc.PoolStats()
+		if stats.TotalConns == stats.FreeConns {
+			break
+		}
+		time.Sleep(reloadDrainPollInterval)
+	}
+	if err := rc.Close(); err != nil {
+		log.Debugf("Unable to close old client for %v: %v", host, err)
+	}
+}
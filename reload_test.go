@@ -0,0 +1,119 @@
+package tlsredis
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+func TestReload(t *testing.T) {
+	srv, caFile := startTLSFakeRedis(t)
+	closeClientOnCleanup(t, srv.url())
+	opts := &Options{RedisURL: srv.url(), RedisCAFile: caFile}
+	original, err := GetClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the CA, the new client can't connect
+	untrusted := &Options{}
+	untrusted.Password = "new"
+	if _, err := Reload(srv.url(), untrusted); err == nil {
+		t.Fatal("Expected reloading with an untrusted server to fail")
+	}
+	if rc, _ := GetClient(opts); rc != original {
+		t.Error("Expected a failed reload to keep the original client cached")
+	}
+	if err := original.Ping().Err(); err != nil {
+		t.Errorf("Expected the original client to remain usable: %v", err)
+	}
+
+	newOpts := &Options{RedisCAFile: caFile}
+	newOpts.Password = "new"
+	reloaded, err := Reload(srv.url(), newOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded == original {
+		t.Fatal("Expected a new client")
+	}
+	if !srv.received("AUTH", "new") {
+		t.Error("Expected the new client to use the new password")
+	}
+	if rc, _ := GetClient(opts); rc != reloaded {
+		t.Error("Expected the new client to replace the original in the cache")
+	}
+	for deadline := time.Now().Add(2 * time.Second); original.Ping().Err() == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the original client to be closed after draining")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloadAll(t *testing.T) {
+	reloaded, failing, untouched := startFakeRedis(t, nil), startFakeRedis(t, nil), startFakeRedis(t, nil)
+	secure, caFile := startTLSFakeRedis(t)
+	// The client for the TLS server wrongly uses a redis URL, which ReloadAll
+	// switches to rediss
+	insecureURL := "redis://" + secure.addr
+	clients := make(map[string]*redis.Client)
+	for _, redisURL := range []string{reloaded.url(), failing.url(), untouched.url(), insecureURL} {
+		closeClientOnCleanup(t, redisURL)
+		rc, err := GetClient(&Options{RedisURL: redisURL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients[redisURL+"/0"] = rc
+	}
+	closeClientOnCleanup(t, secure.url())
+
+	err := ReloadAll(func(cachedURL string) *Options {
+		switch cachedURL {
+		case reloaded.url() + "/0":
+			opts := &Options{}
+			opts.Password = "new"
+			return opts
+		case failing.url() + "/0":
+			// The server doesn't speak TLS
+			return &Options{RedisURL: "rediss://" + failing.addr, DialTimeout: time.Second}
+		case insecureURL + "/0":
+			return &Options{RedisURL: secure.url(), RedisCAFile: caFile}
+		}
+		return nil
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "Unable to reload 1 client(s): "+failing.url()+"/0: ") {
+		t.Fatalf("Expected an error for the failing URL only, got %v", err)
+	}
+
+	cached := make(map[string]bool)
+	for _, cachedURL := range CachedURLs() {
+		cached[cachedURL] = true
+	}
+	for _, expected := range []string{reloaded.url() + "/0", failing.url() + "/0", untouched.url() + "/0", secure.url() + "/0"} {
+		if !cached[expected] {
+			t.Errorf("Expected a client for %v to be cached, got %v", expected, CachedURLs())
+		}
+	}
+	if cached[insecureURL+"/0"] {
+		t.Errorf("Expected the redis client for %v to be replaced by a rediss one", secure.addr)
+	}
+
+	if !reloaded.received("AUTH", "new") {
+		t.Error("Expected the reloaded client to use the new password")
+	}
+	if rc, _ := GetClient(&Options{RedisURL: reloaded.url()}); rc == clients[reloaded.url()+"/0"] {
+		t.Error("Expected a new client for the reloaded URL")
+	}
+	for _, redisURL := range []string{failing.url(), untouched.url()} {
+		rc, _ := GetClient(&Options{RedisURL: redisURL})
+		if rc != clients[redisURL+"/0"] {
+			t.Errorf("Expected the original client for %v to stay cached", redisURL)
+		}
+		if err := rc.Ping().Err(); err != nil {
+			t.Errorf("Expected the original client for %v to remain usable: %v", redisURL, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package tlsredis
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+
+	"gopkg.in/redis.v5"
+)
+
+// GetReplicaClient gets a client for reading from the replica at replicaURL,
+// using opts for everything but the URL. It checks the replica's role with
+// INFO replication and logs a warning if it's actually a master, which usually
+// means that it was promoted, but still returns it since reads will work. If
+// the replica can't be reached at all, it falls back to the primary at
+// primaryURL. Like GetClient, the returned clients are cached.
+func GetReplicaClient(primaryURL string, replicaURL string, opts *Options) (*redis.Client, error) {
+	u, err := parseURL(replicaURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := getClient(opts, u, opts.db(u))
+	if err == nil {
+		var role string
+		role, err = replicationRole(rc)
+		if err == nil {
+			if role != "slave" {
+				log.Errorf("WARNING: Expected Redis at %v to be a replica but its role is %v", u.Host, role)
+			}
+			return rc, nil
+		}
+	}
+
+	log.Errorf("Unable to use Redis replica at %v, falling back to primary: %v", u.Host, err)
+	u, err = parseURL(primaryURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	return getClient(opts, u, opts.db(u))
+}
+
+// replicationRole returns the role (master or slave) that rc's server reports
+// in INFO replication.
+func replicationRole(rc *redis.Client) (string, error) {
+	info, err := rc.Info("replication").Result()
+	if err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "role:") {
+			return strings.TrimPrefix(line, "role:"), nil
+		}
+	}
+	return "", errors.New("No role in INFO replication")
+}
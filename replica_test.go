@@ -0,0 +1,50 @@
+package tlsredis
+
+import (
+	"testing"
+	"time"
+)
+
+// replyWithRole makes srv report role in INFO replication.
+func replyWithRole(srv *fakeRedis, role string) {
+	srv.setReply(func(args []string) string {
+		if args[0] == "INFO" {
+			return bulkString("# Replication\r\nrole:" + role + "\r\nconnected_slaves:0\r\n")
+		}
+		return ""
+	})
+}
+
+func TestGetReplicaClient(t *testing.T) {
+	primary, replica := startFakeRedis(t, nil), startFakeRedis(t, nil)
+	closeClientOnCleanup(t, primary.url())
+	closeClientOnCleanup(t, replica.url())
+	replyWithRole(primary, "master")
+	replyWithRole(replica, "slave")
+
+	rc, err := GetReplicaClient(primary.url(), replica.url(), &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role, err := replicationRole(rc); err != nil || role != "slave" {
+		t.Errorf("Expected a client for the replica, got role %q (%v)", role, err)
+	}
+
+	// A promoted replica is still used, with a warning
+	replyWithRole(replica, "master")
+	if promoted, err := GetReplicaClient(primary.url(), replica.url(), &Options{}); err != nil {
+		t.Fatal(err)
+	} else if promoted != rc {
+		t.Error("Expected to keep using the promoted replica")
+	}
+
+	unreachable := "redis://127.0.0.1:1"
+	closeClientOnCleanup(t, unreachable)
+	rc, err = GetReplicaClient(primary.url(), unreachable, &Options{DialTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role, err := replicationRole(rc); err != nil || role != "master" {
+		t.Errorf("Expected to fall back to the primary, got role %q (%v)", role, err)
+	}
+}
@@ -0,0 +1,87 @@
+package tlsredis
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultDialRetryBackoff = 100 * time.Millisecond
+	maxDialRetryBackoff     = 10 * time.Second
+)
+
+// dialRetryBackoff returns the initial retry backoff configured in opts.
+func dialRetryBackoff(opts *Options) time.Duration {
+	if opts.DialRetryBackoff > 0 {
+		return opts.DialRetryBackoff
+	}
+	return defaultDialRetryBackoff
+}
+
+// isRetryable returns the IsRetryable configured in opts, or the default.
+func (opts *Options) isRetryable() func(error) bool {
+	if opts.IsRetryable != nil {
+		return opts.IsRetryable
+	}
+	return isRetryableDialError
+}
+
+// isRetryableDialError is the default IsRetryable. It retries timeouts and
+// refused connections, which are typical of Redis restarting or failing over.
+func isRetryableDialError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// withDialRetries wraps dial so that failed dials for which isRetryable returns
+// true are retried up to maxRetries times, waiting retryDelay between attempts.
+// It gives up early once ctx is done.
+func withDialRetries(ctx context.Context, dial func() (net.Conn, error), isRetryable func(error) bool, maxRetries int, backoff time.Duration, jitter bool) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		conn, err := dial()
+		for attempt := 0; err != nil && attempt < maxRetries; attempt++ {
+			if !isRetryable(err) {
+				log.Debugf("Dial failed (%v), not retrying", err)
+				break
+			}
+			delay := retryDelay(attempt, backoff, jitter)
+			log.Debugf("Dial failed (%v), retrying in %v", err, delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			conn, err = dial()
+		}
+		return conn, err
+	}
+}
+
+// retryDelay computes how long to wait before retry number attempt (counting
+// from 0). The delay grows exponentially from backoff and is capped at
+// maxDialRetryBackoff. With jitter, the actual delay is chosen uniformly at
+// random between 0 and that value ("full jitter"), which keeps many clients
+// that failed at the same time from retrying in lockstep.
+func retryDelay(attempt int, backoff time.Duration, jitter bool) time.Duration {
+	delay := maxDialRetryBackoff
+	if attempt < 32 {
+		if d := backoff << uint(attempt); d > 0 && d < maxDialRetryBackoff {
+			delay = d
+		}
+	}
+	if jitter {
+		delay = time.Duration(rand.Int63n(int64(delay) + 1))
+	}
+	return delay
+}
@@ -0,0 +1,90 @@
+package tlsredis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	backoff := 100 * time.Millisecond
+	for attempt, expected := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		if delay := retryDelay(attempt, backoff, false); delay != expected {
+			t.Errorf("Attempt %d: expected %v without jitter, got %v", attempt, expected, delay)
+		}
+	}
+	if delay := retryDelay(20, backoff, false); delay != maxDialRetryBackoff {
+		t.Errorf("Expected delay to be capped at %v, got %v", maxDialRetryBackoff, delay)
+	}
+	if delay := retryDelay(100, backoff, false); delay != maxDialRetryBackoff {
+		t.Errorf("Expected delay not to overflow, got %v", delay)
+	}
+
+	distinct := make(map[time.Duration]bool)
+	var sum time.Duration
+	const samples = 1000
+	for i := 0; i < samples; i++ {
+		delay := retryDelay(2, backoff, true)
+		if delay < 0 || delay > 400*time.Millisecond {
+			t.Fatalf("Jittered delay %v out of range", delay)
+		}
+		distinct[delay] = true
+		sum += delay
+	}
+	if len(distinct) < samples/2 {
+		t.Errorf("Expected jittered delays to be spread out, only got %d distinct values", len(distinct))
+	}
+	// Full jitter averages half the backoff
+	if mean := sum / samples; mean < 150*time.Millisecond || mean > 250*time.Millisecond {
+		t.Errorf("Expected jittered delays to average about 200ms, got %v", mean)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	noSuchHost := &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "redis.invalid", IsNotFound: true}}
+	unreachable := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)}
+	timeout := &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "i/o timeout", Name: "redis.invalid", IsTimeout: true}}
+	for _, tc := range []struct {
+		err       error
+		retryable bool
+	}{
+		{refused, true},
+		{noSuchHost, false},
+		{unreachable, false},
+		{timeout, false},
+		{context.DeadlineExceeded, true},
+		{errors.New("x509: certificate signed by unknown authority"), false},
+	} {
+		if retryable := isRetryableDialError(tc.err); retryable != tc.retryable {
+			t.Errorf("%v: expected retryable to be %v", tc.err, tc.retryable)
+		}
+	}
+
+	for _, tc := range []struct {
+		err      error
+		opts     *Options
+		attempts int
+	}{
+		{refused, &Options{}, 3},
+		{noSuchHost, &Options{}, 1},
+		{noSuchHost, &Options{IsRetryable: func(error) bool { return true }}, 3},
+		{refused, &Options{IsRetryable: func(error) bool { return false }}, 1},
+	} {
+		attempts := 0
+		dial := withDialRetries(context.Background(), func() (net.Conn, error) {
+			attempts++
+			return nil, tc.err
+		}, tc.opts.isRetryable(), 2, time.Millisecond, false)
+		if _, err := dial(); err != tc.err {
+			t.Errorf("Expected the dial error to be returned, got %v", err)
+		}
+		if attempts != tc.attempts {
+			t.Errorf("%v: expected %d attempts, got %d", tc.err, tc.attempts, attempts)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package tlsredis
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reverseDNSCacheTTL is how long the names found for an IP are reused.
+const reverseDNSCacheTTL = 5 * time.Minute
+
+var (
+	// lookupAddr performs reverse DNS lookups. It's a variable so that it can
+	// be stubbed out.
+	lookupAddr = net.DefaultResolver.LookupAddr
+
+	reverseDNSCache      = make(map[string]reverseDNSEntry)
+	reverseDNSCacheMutex sync.Mutex
+)
+
+type reverseDNSEntry struct {
+	names     []string
+	expiresAt time.Time
+}
+
+// reverseDNSConfig returns a copy of tlsConfig that verifies the server
+// certificate against the names that the IP address conn is connected to
+// resolves to.
+func reverseDNSConfig(ctx context.Context, tlsConfig *tls.Config, conn net.Conn) (*tls.Config, error) {
+	host, err := remoteHost(conn)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("Unable to verify server via reverse DNS, %v is not an IP address", host)
+	}
+	names, err := reverseLookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Verifying server certificate for %v against reverse DNS names %v", host, names)
+	tlsConfig = tlsConfig.Clone()
+	verifyServerNames(tlsConfig, names)
+	return tlsConfig, nil
+}
+
+// reverseLookup returns the names for ip, using the cache if possible.
+func reverseLookup(ctx context.Context, ip string) ([]string, error) {
+	reverseDNSCacheMutex.Lock()
+	entry, found := reverseDNSCache[ip]
+	reverseDNSCacheMutex.Unlock()
+	if found && now().Before(entry.expiresAt) {
+		return entry.names, nil
+	}
+
+	names, err := lookupAddr(ctx, ip)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to look up reverse DNS for %v: %v", ip, err)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("No reverse DNS names found for %v", ip)
+	}
+	for i, name := range names {
+		names[i] = strings.TrimSuffix(name, ".")
+	}
+
+	reverseDNSCacheMutex.Lock()
+	reverseDNSCache[ip] = reverseDNSEntry{names: names, expiresAt: now().Add(reverseDNSCacheTTL)}
+	reverseDNSCacheMutex.Unlock()
+	return names, nil
+}
@@ -0,0 +1,111 @@
+package tlsredis
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyViaReverseDNS(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	cert := issueCert(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "redis"},
+		DNSNames:    []string{"redis-0.mesh.internal"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	srv := startFakeRedis(t, serverTLSConfig(cert))
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM())
+
+	lookups := 0
+	origLookupAddr := lookupAddr
+	lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		if addr != "127.0.0.1" {
+			t.Errorf("Unexpected reverse lookup for %v", addr)
+		}
+		return []string{"redis-0.mesh.internal."}, nil
+	}
+	t.Cleanup(func() {
+		lookupAddr = origLookupAddr
+		reverseDNSCacheMutex.Lock()
+		reverseDNSCache = make(map[string]reverseDNSEntry)
+		reverseDNSCacheMutex.Unlock()
+	})
+
+	// The certificate has no IP SAN, so it only verifies via the PTR name
+	plain, err := BuildDialer(&Options{RedisURL: srv.url(), RedisCAFile: caFile, DialTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain(); err == nil {
+		t.Fatal("Expected verification against the IP to fail")
+	}
+
+	dial, err := BuildDialer(&Options{RedisURL: srv.url(), RedisCAFile: caFile, VerifyViaReverseDNS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		conn, err := dial()
+		if err != nil {
+			t.Fatalf("Expected verification against reverse DNS to succeed: %v", err)
+		}
+		pingConn(t, conn)
+		conn.Close()
+	}
+	if lookups != 1 {
+		t.Errorf("Expected the reverse lookup to be cached, got %d lookups", lookups)
+	}
+
+	reverseDNSCacheMutex.Lock()
+	reverseDNSCache = make(map[string]reverseDNSEntry)
+	reverseDNSCacheMutex.Unlock()
+	lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		return []string{"other.mesh.internal"}, nil
+	}
+	if _, err := dial(); err == nil || !strings.Contains(err.Error(), "not valid for any of [other.mesh.internal]") {
+		t.Errorf("Expected verification against the wrong name to fail, got %v", err)
+	}
+
+	if _, err := BuildDialer(&Options{RedisURL: srv.url(), VerifyViaReverseDNS: true, VerifyServerName: "redis"}); err == nil {
+		t.Error("Expected VerifyViaReverseDNS and VerifyServerName to be rejected together")
+	}
+}
+
+// remoteAddrConn is a net.Conn that only knows its remote address.
+type remoteAddrConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (conn *remoteAddrConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+func TestReverseDNSConfigStripsZone(t *testing.T) {
+	origLookupAddr := lookupAddr
+	var looked string
+	lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		looked = addr
+		return []string{"redis-0.mesh.internal."}, nil
+	}
+	t.Cleanup(func() {
+		lookupAddr = origLookupAddr
+		reverseDNSCacheMutex.Lock()
+		reverseDNSCache = make(map[string]reverseDNSEntry)
+		reverseDNSCacheMutex.Unlock()
+	})
+
+	conn := &remoteAddrConn{remoteAddr: &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 6380, Zone: "eth0"}}
+	if _, err := reverseDNSConfig(context.Background(), &tls.Config{}, conn); err != nil {
+		t.Fatal(err)
+	}
+	if looked != "fe80::1" {
+		t.Errorf("Expected the zone to be stripped before the lookup, got %v", looked)
+	}
+}
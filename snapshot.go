@@ -0,0 +1,57 @@
+package tlsredis
+
+import (
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// ClientSnapshot describes the state of a cached client.
+type ClientSnapshot struct {
+	// PoolStats are the client's connection pool stats.
+	PoolStats redis.PoolStats
+
+	// TLS indicates whether the client has made a TLS connection. If not, the
+	// TLS related fields are all zero.
+	TLS bool
+
+	// TLSVersion and CipherSuite are the ones negotiated on the client's most
+	// recent TLS connection, e.g. tls.VersionTLS13.
+	TLSVersion  uint16
+	CipherSuite uint16
+
+	// ServerCertExpiry is when the server certificate presented on the client's
+	// most recent TLS connection expires.
+	ServerCertExpiry time.Time
+
+	// MutualTLS indicates whether the client is configured to authenticate
+	// itself with a client certificate.
+	MutualTLS bool
+}
+
+// Snapshot returns a snapshot of all cached clients, for use in status pages
+// and the like. It's keyed the same way as the cache: by host and database like
+// "redis.example.com:6380/0", with "/pubsub" appended for clients from
+// GetPubSubClient, unless a CacheKeyFunc was used to build the key.
+func Snapshot() map[string]ClientSnapshot {
+	rcsMutex.Lock()
+	defer rcsMutex.Unlock()
+
+	snapshots := make(map[string]ClientSnapshot, len(rcs))
+	for key, cc := range rcs {
+		snapshot := ClientSnapshot{
+			PoolStats: *cc.client.PoolStats(),
+			MutualTLS: cc.mutualTLS,
+		}
+		if state, found := cc.connState.get(); found {
+			snapshot.TLS = true
+			snapshot.TLSVersion = state.Version
+			snapshot.CipherSuite = state.CipherSuite
+			if len(state.PeerCertificates) > 0 {
+				snapshot.ServerCertExpiry = state.PeerCertificates[0].NotAfter
+			}
+		}
+		snapshots[key] = snapshot
+	}
+	return snapshots
+}
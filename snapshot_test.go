@@ -0,0 +1,53 @@
+package tlsredis
+
+import (
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	cert := newServerCert(t, ca)
+	srv := startFakeRedis(t, serverTLSConfig(cert))
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM())
+	closeClientOnCleanup(t, srv.url())
+	closeClientOnCleanup(t, "redis://"+srv.addr+"/1")
+
+	opts := &Options{RedisURL: srv.url(), RedisCAFile: caFile}
+	rc, err := GetClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	// Neither of these has made a TLS connection
+	if _, err := GetPubSubClient(&Options{RedisURL: srv.url(), RedisCAFile: caFile}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetClientForDB("redis://"+srv.addr, 1, &Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots := Snapshot()
+	snapshot, found := snapshots[srv.addr+"/0"]
+	if !found {
+		t.Fatalf("Expected a snapshot for the client, got %v", snapshots)
+	}
+	if !snapshot.TLS || snapshot.TLSVersion == 0 || snapshot.CipherSuite == 0 {
+		t.Errorf("Expected the negotiated TLS details, got %+v", snapshot)
+	}
+	if !snapshot.ServerCertExpiry.Equal(cert.cert.NotAfter) {
+		t.Errorf("Expected server certificate expiry %v, got %v", cert.cert.NotAfter, snapshot.ServerCertExpiry)
+	}
+	if snapshot.PoolStats.TotalConns != 1 || snapshot.MutualTLS {
+		t.Errorf("Unexpected snapshot %+v", snapshot)
+	}
+
+	for _, key := range []string{srv.addr + "/0/pubsub", srv.addr + "/1"} {
+		if snapshot, found := snapshots[key]; !found {
+			t.Errorf("Expected a snapshot for %v", key)
+		} else if snapshot.TLS || snapshot.TLSVersion != 0 || !snapshot.ServerCertExpiry.IsZero() {
+			t.Errorf("Expected no TLS details for %v, which hasn't made a TLS connection, got %+v", key, snapshot)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package tlsredis
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// testCert is a certificate and its private key, for building test PKIs.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issueCert creates a certificate from tmpl signed by issuer, or a self-signed
+// one if issuer is nil. The serial number and validity are filled in if tmpl
+// doesn't set them.
+func issueCert(t testing.TB, issuer *testCert, tmpl *x509.Certificate) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl.SerialNumber == nil {
+		serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.SerialNumber = serial
+	}
+	if tmpl.NotBefore.IsZero() {
+		tmpl.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if tmpl.NotAfter.IsZero() {
+		tmpl.NotAfter = time.Now().Add(time.Hour)
+	}
+	parent, signer := tmpl, key
+	if issuer != nil {
+		parent, signer = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// newTestCA creates a self-signed CA certificate.
+func newTestCA(t testing.TB, name string) *testCert {
+	return issueCert(t, nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+}
+
+// newServerCert creates a server certificate for 127.0.0.1 and localhost
+// signed by ca.
+func newServerCert(t *testing.T, ca *testCert) *testCert {
+	return issueCert(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "redis"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+}
+
+// newClientCert creates a client certificate signed by ca.
+func newClientCert(t *testing.T, ca *testCert) *testCert {
+	return issueCert(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+}
+
+func (c *testCert) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+}
+
+func (c *testCert) keyPEM() []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		panic(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+// serverTLSConfig returns a server side tls.Config presenting cert.
+func serverTLSConfig(cert *testCert) *tls.Config {
+	return &tls.Config{Certificates: []tls.Certificate{cert.tlsCertificate()}}
+}
+
+// writeTestFile writes data to a file called name in a temporary directory
+// and returns its path.
+func writeTestFile(t testing.TB, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fakeRedis is a minimal Redis server that records the commands it receives.
+// It answers PING with PONG and everything else with OK, unless reply says
+// otherwise.
+type fakeRedis struct {
+	addr     string
+	tls      bool
+	listener net.Listener
+
+	mx       sync.Mutex
+	reply    func(args []string) string
+	commands [][]string
+	conns    []net.Conn
+}
+
+// startFakeRedis starts a fakeRedis on a random local port, speaking TLS if
+// tlsConfig is given. It's stopped when the test finishes.
+func startFakeRedis(t *testing.T, tlsConfig *tls.Config) *fakeRedis {
+	t.Helper()
+	var l net.Listener
+	var err error
+	if tlsConfig != nil {
+		l, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := serveTestListener(t, l)
+	srv.tls = tlsConfig != nil
+	return srv
+}
+
+// serveTestListener starts a fakeRedis accepting connections from l. It's
+// stopped when the test finishes.
+func serveTestListener(t *testing.T, l net.Listener) *fakeRedis {
+	srv := &fakeRedis{addr: l.Addr().String(), listener: l}
+	go srv.serve()
+	t.Cleanup(srv.close)
+	return srv
+}
+
+// startTLSFakeRedis starts a TLS fakeRedis with a certificate for 127.0.0.1
+// issued by a new CA and returns it along with the path of the CA file.
+func startTLSFakeRedis(t *testing.T) (*fakeRedis, string) {
+	ca := newTestCA(t, "Test CA")
+	srv := startFakeRedis(t, serverTLSConfig(newServerCert(t, ca)))
+	return srv, writeTestFile(t, "ca.pem", ca.certPEM())
+}
+
+// url returns the URL of srv, with the rediss scheme if it speaks TLS.
+func (srv *fakeRedis) url() string {
+	if srv.tls {
+		return "rediss://" + srv.addr
+	}
+	return "redis://" + srv.addr
+}
+
+// setReply makes srv answer commands with the RESP encoded result of reply,
+// or the default answer if that's empty.
+func (srv *fakeRedis) setReply(reply func(args []string) string) {
+	srv.mx.Lock()
+	srv.reply = reply
+	srv.mx.Unlock()
+}
+
+// recorded returns the commands received so far, each as its name (in upper
+// case) followed by its arguments.
+func (srv *fakeRedis) recorded() [][]string {
+	srv.mx.Lock()
+	defer srv.mx.Unlock()
+	return append([][]string(nil), srv.commands...)
+}
+
+// recordedNames returns the names of the commands received so far.
+func (srv *fakeRedis) recordedNames() []string {
+	var names []string
+	for _, args := range srv.recorded() {
+		names = append(names, args[0])
+	}
+	return names
+}
+
+// received reports whether srv received the command args, with the name in
+// upper case.
+func (srv *fakeRedis) received(args ...string) bool {
+	for _, cmd := range srv.recorded() {
+		if strings.Join(cmd, " ") == strings.Join(args, " ") {
+			return true
+		}
+	}
+	return false
+}
+
+// dropConns closes all connections accepted so far.
+func (srv *fakeRedis) dropConns() {
+	srv.mx.Lock()
+	conns := srv.conns
+	srv.conns = nil
+	srv.mx.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func (srv *fakeRedis) close() {
+	srv.listener.Close()
+	srv.dropConns()
+}
+
+func (srv *fakeRedis) serve() {
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+		srv.mx.Lock()
+		srv.conns = append(srv.conns, conn)
+		srv.mx.Unlock()
+		go srv.serveConn(conn)
+	}
+}
+
+func (srv *fakeRedis) serveConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		if first, err := r.Peek(1); err != nil {
+			return
+		} else if first[0] != '*' {
+			// Like Redis, reject anything that isn't RESP, e.g. a TLS handshake
+			io.WriteString(conn, "-ERR Protocol error: expected '*'\r\n")
+			return
+		}
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		args[0] = strings.ToUpper(args[0])
+		srv.mx.Lock()
+		srv.commands = append(srv.commands, args)
+		reply := srv.reply
+		srv.mx.Unlock()
+
+		resp := ""
+		if reply != nil {
+			resp = reply(args)
+		}
+		if resp == "" {
+			resp = "+OK\r\n"
+			if args[0] == "PING" {
+				resp = "+PONG\r\n"
+			}
+		}
+		if _, err := io.WriteString(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads a RESP array of bulk strings from r.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("Unexpected command %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("Bad array length in %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("Bad bulk string length in %q", header)
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+// bulkString encodes s as a RESP bulk string.
+func bulkString(s string) string {
+	return fmt.Sprintf("$%d\r\n%v\r\n", len(s), s)
+}
+
+// closeClientOnCleanup removes the cached clients for redisURL when the test
+// finishes.
+func closeClientOnCleanup(t *testing.T, redisURL string) {
+	t.Cleanup(func() {
+		CloseClient(redisURL)
+	})
+}
+
+// pingConn sends PING over the raw connection conn and checks the reply.
+func pingConn(t *testing.T, conn net.Conn) {
+	t.Helper()
+	if _, err := io.WriteString(conn, "*1\r\n$4\r\nPING\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 7)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "+PONG\r\n" {
+		t.Fatalf("Unexpected reply to PING: %q", reply)
+	}
+}
+
+// clientOption returns the integer (or duration) field called name of the
+// options rc ended up with after redis filled in its defaults. redis.v5 doesn't
+// expose those, so this digs them out.
+func clientOption(rc *redis.Client, name string) int64 {
+	return reflect.ValueOf(rc).Elem().FieldByName("opt").Elem().FieldByName(name).Int()
+}
+
+// clientStringOption is like clientOption for string fields.
+func clientStringOption(rc *redis.Client, name string) string {
+	return reflect.ValueOf(rc).Elem().FieldByName("opt").Elem().FieldByName(name).String()
+}
@@ -0,0 +1,64 @@
+package tlsredis
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+
+	"gopkg.in/redis.v5"
+)
+
+// NewClientWithTLSConfig builds a client that connects to addr (host:port)
+// using tlsConfig as is, for callers that assemble their TLS configuration
+// elsewhere. If tlsConfig doesn't set a ServerName, the host in addr is used.
+// A nil tlsConfig means a plaintext connection, which is refused if opts sets
+// RequireTLS. opts supplies the remaining dialer and pool settings and may be
+// nil. Its RedisURL, DB and password options (Password, PasswordProvider,
+// PasswordFile and PasswordEnv) are ignored in favor of the arguments, as are
+// the options it has for building or adjusting a TLS configuration, like
+// RedisCAFile, VerifyViaReverseDNS and VerifyPeerIP. The client isn't cached
+// and should be closed by the caller.
+func NewClientWithTLSConfig(addr string, db int, password string, tlsConfig *tls.Config, opts *Options) (*redis.Client, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("Unable to parse Redis address %v: %v", addr, err)
+	}
+	var clientOpts Options
+	if opts != nil {
+		clientOpts = *opts
+	}
+	if clientOpts.RequireTLS {
+		if tlsConfig == nil {
+			return nil, fmt.Errorf("No tlsConfig given for Redis at %v but RequireTLS is set", addr)
+		}
+		if clientOpts.FallbackToPlaintext {
+			return nil, fmt.Errorf("FallbackToPlaintext can't be used with RequireTLS")
+		}
+	}
+	// The password argument takes the place of all other password sources
+	clientOpts.Password = password
+	clientOpts.PasswordProvider = nil
+	clientOpts.PasswordFile = ""
+	clientOpts.PasswordEnv = ""
+	// tlsConfig is used as is, so don't change who it verifies at dial time
+	clientOpts.VerifyViaReverseDNS = false
+	clientOpts.VerifyPeerIP = false
+	clientOpts.tlsConfig = tlsConfig
+
+	u := &url.URL{Scheme: "redis", Host: addr}
+	if tlsConfig != nil {
+		u.Scheme = "rediss"
+	}
+	rc, err := newClient(context.Background(), &clientOpts, u, db, nil)
+	if err != nil {
+		return nil, err
+	}
+	if clientOpts.VerifyOnConnect {
+		if err := verifyConnection(context.Background(), rc, &clientOpts, u.Host); err != nil {
+			rc.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
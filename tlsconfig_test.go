@@ -0,0 +1,86 @@
+package tlsredis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+)
+
+func TestNewClientWithTLSConfig(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	srv := startFakeRedis(t, serverTLSConfig(newServerCert(t, ca)))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	// The password argument wins over the password options
+	opts := &Options{
+		VerifyOnConnect: true,
+		PasswordEnv:     "TLSREDIS_TEST_UNSET_PASSWORD",
+		PasswordFile:    "/nonexistent/password",
+		PasswordProvider: func() (string, error) {
+			return "provided", nil
+		},
+	}
+	opts.Password = "ignored"
+	rc, err := NewClientWithTLSConfig(srv.addr, 2, "s3cret", &tls.Config{RootCAs: pool}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if !srv.received("AUTH", "s3cret") || srv.received("AUTH", "provided") || srv.received("AUTH", "ignored") {
+		t.Errorf("Expected to authenticate with the password argument, got %v", srv.recorded())
+	}
+	if !srv.received("SELECT", "2") {
+		t.Errorf("Expected DB 2 to be selected, got %v", srv.recorded())
+	}
+	for _, url := range CachedURLs() {
+		if strings.Contains(url, srv.addr) {
+			t.Errorf("Expected the client not to be cached, got %v", url)
+		}
+	}
+
+	if _, err := NewClientWithTLSConfig(srv.addr, 0, "", &tls.Config{}, &Options{VerifyOnConnect: true}); err == nil {
+		t.Error("Expected a tlsConfig without the CA to fail verification")
+	}
+
+	plaintext := startFakeRedis(t, nil)
+	rc, err = NewClientWithTLSConfig(plaintext.addr, 0, "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if err := rc.Ping().Err(); err != nil {
+		t.Errorf("Expected a nil tlsConfig to connect in plaintext: %v", err)
+	}
+	if _, err := NewClientWithTLSConfig(plaintext.addr, 0, "", nil, &Options{RequireTLS: true}); err == nil || !strings.Contains(err.Error(), "RequireTLS") {
+		t.Errorf("Expected RequireTLS to refuse a nil tlsConfig, got %v", err)
+	}
+	rc, err = NewClientWithTLSConfig(srv.addr, 0, "", &tls.Config{RootCAs: pool}, &Options{RequireTLS: true})
+	if err != nil {
+		t.Errorf("Expected RequireTLS to accept a tlsConfig: %v", err)
+	} else {
+		rc.Close()
+	}
+
+	// The certificate only names the host, so verifying it against the IP
+	// connected to would fail
+	named := startFakeRedis(t, serverTLSConfig(issueCert(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "redis"},
+		DNSNames:    []string{"redis.internal"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})))
+	rc, err = NewClientWithTLSConfig(named.addr, 0, "", &tls.Config{RootCAs: pool, ServerName: "redis.internal"}, &Options{VerifyPeerIP: true, VerifyViaReverseDNS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if err := rc.Ping().Err(); err != nil {
+		t.Errorf("Expected tlsConfig to be used as is, ignoring VerifyPeerIP and VerifyViaReverseDNS: %v", err)
+	}
+
+	if _, err := NewClientWithTLSConfig("localhost", 0, "", nil, nil); err == nil || !strings.Contains(err.Error(), "Unable to parse Redis address") {
+		t.Errorf("Expected an error for an address without a port, got %v", err)
+	}
+}
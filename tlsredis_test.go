@@ -0,0 +1,860 @@
+package tlsredis
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+func TestGetClientForDB(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	closeClientOnCleanup(t, srv.url()+"/2")
+
+	rc0, err := GetClientForDB(srv.url(), 0, &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc2, err := GetClientForDB(srv.url()+"/5", 2, &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc0 == rc2 {
+		t.Fatal("Expected separate clients for DB 0 and DB 2")
+	}
+	again, err := GetClientForDB(srv.url(), 2, &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != rc2 {
+		t.Error("Expected the cached client for DB 2")
+	}
+
+	if err := rc2.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.received("SELECT", "2") {
+		t.Errorf("Expected DB 2 to be selected, got %v", srv.recorded())
+	}
+	if srv.received("SELECT", "5") {
+		t.Error("Database in the URL path should be ignored")
+	}
+	if err := rc0.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if srv.received("SELECT", "0") {
+		t.Error("DB 0 shouldn't need selecting")
+	}
+}
+
+func TestWatchCertFiles(t *testing.T) {
+	srv, caFile := startTLSFakeRedis(t)
+	closeClientOnCleanup(t, srv.url())
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &Options{RedisURL: srv.url(), RedisCAFile: caFile, WatchCertFiles: true}
+	mtime := time.Now()
+	rewrite := func(data []byte) {
+		t.Helper()
+		if err := ioutil.WriteFile(caFile, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		mtime = mtime.Add(time.Minute)
+		if err := os.Chtimes(caFile, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	original, err := GetClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc, _ := GetClient(opts); rc != original {
+		t.Fatal("Expected the cached client while the CA file is unchanged")
+	}
+
+	rewrite(caPEM)
+	rebuilt, err := GetClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt == original {
+		t.Fatal("Expected a new client after the CA file changed")
+	}
+	if err := original.Ping().Err(); err == nil {
+		t.Error("Expected the old client to be closed")
+	}
+	if err := rebuilt.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	rewrite([]byte("half written"))
+	rc, err := GetClient(opts)
+	if err != nil {
+		t.Fatalf("A failed rebuild should keep the old client: %v", err)
+	}
+	if rc != rebuilt {
+		t.Fatal("Expected the old client while the CA file is broken")
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	rewrite(caPEM)
+	rc, err = GetClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc == rebuilt {
+		t.Error("Expected the rebuild to be retried once the CA file is fixed")
+	}
+}
+
+func TestPing(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	opts := &Options{RedisURL: srv.url()}
+
+	if err := Ping(context.Background(), opts); err != nil {
+		t.Fatalf("Expected healthy server to respond to ping: %v", err)
+	}
+	rc, err := GetClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Ping(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := GetClient(opts); again != rc {
+		t.Error("Expected Ping to reuse the cached client")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Ping(ctx, opts); err != context.Canceled {
+		t.Errorf("Expected context.Canceled for a cancelled context, got %v", err)
+	}
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+	srv.setReply(func(args []string) string {
+		<-unblock
+		return ""
+	})
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := Ping(ctx, opts); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded for a hanging server, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Ping to give up promptly, took %v", elapsed)
+	}
+}
+
+func TestAggregateStats(t *testing.T) {
+	baseline := AggregateStats()
+	var clients []*redis.Client
+	for i := 0; i < 2; i++ {
+		srv := startFakeRedis(t, nil)
+		closeClientOnCleanup(t, srv.url())
+		rc, err := GetClient(&Options{RedisURL: srv.url()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rc.Ping().Err(); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, rc)
+	}
+
+	expected := baseline.TotalConns
+	for _, rc := range clients {
+		expected += rc.PoolStats().TotalConns
+	}
+	if total := AggregateStats().TotalConns; total != expected || total < baseline.TotalConns+2 {
+		t.Errorf("Expected %d total connections across clients, got %d", expected, total)
+	}
+}
+
+func TestOnNewClient(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+
+	var created []*redis.Client
+	opts := &Options{
+		RedisURL: srv.url(),
+		OnNewClient: func(rc *redis.Client) {
+			created = append(created, rc)
+		},
+	}
+	rc, err := GetClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetClient(opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 1 || created[0] != rc {
+		t.Errorf("Expected OnNewClient to be called once with the new client, got %d calls", len(created))
+	}
+}
+
+func TestCloseWithTimeout(t *testing.T) {
+	busy := func(t *testing.T) (*redis.Client, chan struct{}, chan error) {
+		srv := startFakeRedis(t, nil)
+		closeClientOnCleanup(t, srv.url())
+		rc, err := GetClient(&Options{RedisURL: srv.url()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		unblock := make(chan struct{})
+		srv.setReply(func(args []string) string {
+			if args[0] == "GET" {
+				<-unblock
+				return "$-1\r\n"
+			}
+			return ""
+		})
+		result := make(chan error, 1)
+		go func() {
+			result <- rc.Get("key").Err()
+		}()
+		for rc.PoolStats().TotalConns-rc.PoolStats().FreeConns == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		return rc, unblock, result
+	}
+
+	t.Run("drained", func(t *testing.T) {
+		_, unblock, result := busy(t)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			close(unblock)
+		}()
+		start := time.Now()
+		forceClosed, err := CloseWithTimeout(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("Expected to wait for the busy connection, returned after %v", elapsed)
+		}
+		if forceClosed != 0 {
+			t.Errorf("Expected no connections to be force closed, got %d", forceClosed)
+		}
+		if err := <-result; err != redis.Nil {
+			t.Errorf("Expected the in-flight command to complete, got %v", err)
+		}
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		_, unblock, result := busy(t)
+		defer close(unblock)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		forceClosed, _ := CloseWithTimeout(ctx)
+		if forceClosed != 1 {
+			t.Errorf("Expected the busy connection to be force closed, got %d", forceClosed)
+		}
+		if err := <-result; err == nil {
+			t.Error("Expected the in-flight command to fail")
+		}
+	})
+}
+
+func TestURLScheme(t *testing.T) {
+	for _, redisURL := range []string{"redis://localhost:6379", "rediss://localhost:6380", "Redis://localhost", "REDISS://localhost"} {
+		if _, err := parseURL(redisURL, &Options{}); err != nil {
+			t.Errorf("Expected %v to be accepted: %v", redisURL, err)
+		}
+	}
+	for _, redisURL := range []string{"reddis://localhost:6379", "https://localhost", "localhost:6379"} {
+		if _, err := parseURL(redisURL, &Options{}); err == nil || !strings.Contains(err.Error(), "Unsupported Redis URL scheme") {
+			t.Errorf("Expected %v to be rejected for its scheme, got %v", redisURL, err)
+		}
+	}
+
+	// Upper case rediss still means TLS
+	srv, caFile := startTLSFakeRedis(t)
+	dial, err := BuildDialer(&Options{RedisURL: "REDISS://" + srv.addr, RedisCAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	pingConn(t, conn)
+}
+
+func TestVerifyOnConnect(t *testing.T) {
+	// Reserve an address for a server that only starts after a while
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	redisURL := "redis://" + addr
+	closeClientOnCleanup(t, redisURL)
+
+	started := make(chan *fakeRedis, 1)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Error(err)
+			started <- nil
+			return
+		}
+		srv := &fakeRedis{addr: addr, listener: l}
+		go srv.serve()
+		started <- srv
+	}()
+
+	opts := &Options{RedisURL: redisURL, VerifyOnConnect: true, MaxDialRetries: 10, DialRetryBackoff: 20 * time.Millisecond, DisableRetryJitter: true}
+	rc, err := GetClient(opts)
+	if srv := <-started; srv != nil {
+		defer srv.close()
+	}
+	if err != nil {
+		t.Fatalf("Expected GetClient to wait for the server to start: %v", err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = GetClient(&Options{RedisURL: "redis://127.0.0.1:1", VerifyOnConnect: true, MaxDialRetries: 1, DialRetryBackoff: time.Millisecond})
+	if err == nil {
+		t.Fatal("Expected an error when Redis can't be reached")
+	}
+	rcsMutex.Lock()
+	defer rcsMutex.Unlock()
+	for _, cc := range rcs {
+		if cc.host == "127.0.0.1:1" {
+			t.Error("Expected nothing to be cached when the connection can't be verified")
+		}
+	}
+}
+
+func TestDefaultPort(t *testing.T) {
+	for _, tc := range []struct {
+		redisURL    string
+		defaultPort int
+		expected    string
+	}{
+		{"redis://redis.example.com", 0, "redis.example.com:6379"},
+		{"rediss://redis.example.com/2", 0, "redis.example.com:6380"},
+		{"rediss://redis.example.com:7000", 0, "redis.example.com:7000"},
+		{"rediss://redis.example.com", 7001, "redis.example.com:7001"},
+		{"redis://[::1]", 0, "[::1]:6379"},
+	} {
+		u, err := parseURL(tc.redisURL, &Options{DefaultPort: tc.defaultPort})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Host != tc.expected {
+			t.Errorf("%v with default port %d: expected %v, got %v", tc.redisURL, tc.defaultPort, tc.expected, u.Host)
+		}
+	}
+}
+
+func TestGetClientContext(t *testing.T) {
+	// A server that accepts connections but never completes a TLS handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rc, err := GetClientContext(ctx, &Options{RedisURL: "rediss://" + l.Addr().String(), DialTimeout: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	if err := rc.Ping().Err(); err == nil {
+		t.Fatal("Expected the ping to fail once the dial was aborted")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected cancelling the context to abort the handshake, took %v", elapsed)
+	}
+
+	start = time.Now()
+	if err := rc.Ping().Err(); err == nil {
+		t.Error("Expected no new connections once the context is done")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected dials to fail immediately once the context is done, took %v", elapsed)
+	}
+}
+
+func TestConnectDeadline(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	serverConfig := serverTLSConfig(newServerCert(t, ca))
+	serverConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		time.Sleep(400 * time.Millisecond)
+		return nil, nil
+	}
+	srv := startFakeRedis(t, serverConfig)
+	srv.setReply(func(args []string) string {
+		time.Sleep(400 * time.Millisecond)
+		return ""
+	})
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM())
+	closeClientOnCleanup(t, srv.url())
+
+	// Neither the handshake nor the PING take 500ms, but together they do
+	start := time.Now()
+	_, err := GetClient(&Options{RedisURL: srv.url(), RedisCAFile: caFile, VerifyOnConnect: true, ConnectDeadline: 500 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 700*time.Millisecond {
+		t.Errorf("Expected to give up once the deadline passed, took %v", elapsed)
+	}
+
+	if _, err = GetClient(&Options{RedisURL: srv.url(), RedisCAFile: caFile, VerifyOnConnect: true, ConnectDeadline: 2 * time.Second}); err != nil {
+		t.Errorf("Expected to connect within a generous deadline: %v", err)
+	}
+}
+
+func TestCacheKeyFunc(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	// Share clients by the user name in the URL rather than just by host
+	byUser := func(opts *Options, u *url.URL) string {
+		return u.User.Username() + "@" + u.Host + u.Path
+	}
+
+	cache, err := GetClient(&Options{RedisURL: "redis://cache@" + srv.addr, CacheKeyFunc: byUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue, err := GetClient(&Options{RedisURL: "redis://queue@" + srv.addr, CacheKeyFunc: byUser})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache == queue {
+		t.Error("Expected the custom cache key to give clients for the same host separate slots")
+	}
+	if again, _ := GetClient(&Options{RedisURL: "redis://cache@" + srv.addr, CacheKeyFunc: byUser}); again != cache {
+		t.Error("Expected the same custom cache key to share a client")
+	}
+	if _, found := Snapshot()["cache@"+srv.addr+"/0"]; !found {
+		t.Errorf("Expected the client to be cached under the custom key including the database, got %v", Snapshot())
+	}
+	if rc, _ := GetClient(&Options{RedisURL: "redis://cache@" + srv.addr}); rc == cache {
+		t.Error("Expected the default cache key not to collide with the custom one")
+	}
+}
+
+func TestRequireTLS(t *testing.T) {
+	srv, caFile := startTLSFakeRedis(t)
+	closeClientOnCleanup(t, srv.url())
+
+	_, err := GetClient(&Options{RedisURL: "redis://" + srv.addr, RequireTLS: true})
+	if err == nil || !strings.Contains(err.Error(), "RequireTLS") {
+		t.Errorf("Expected a plaintext URL to be rejected, got %v", err)
+	}
+	_, err = GetClient(&Options{RedisURL: srv.url(), RequireTLS: true, FallbackToPlaintext: true})
+	if err == nil {
+		t.Error("Expected FallbackToPlaintext to be rejected with RequireTLS")
+	}
+
+	rc, err := GetClient(&Options{RedisURL: srv.url(), RedisCAFile: caFile, RequireTLS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDisableCustomDialer(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	// DialFunc is called by our dialer, so it isn't without it
+	var dials int32
+	dialFunc := func(network string, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return net.Dial(network, addr)
+	}
+
+	rc, err := GetClient(&Options{RedisURL: srv.url(), DisableCustomDialer: true, DialTimeout: time.Second, DialFunc: dialFunc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&dials) != 0 {
+		t.Error("Expected the built-in dialer to be used")
+	}
+
+	for _, opts := range []*Options{
+		{RedisURL: "rediss://" + srv.addr, DisableCustomDialer: true},
+		{RedisURL: srv.url(), DisableCustomDialer: true, StartupCommands: [][]interface{}{{"PING"}}},
+	} {
+		if _, err := GetClientContext(context.Background(), opts); err == nil {
+			t.Errorf("Expected DisableCustomDialer to be rejected for %v", opts.RedisURL)
+		}
+	}
+}
+
+func TestURLEncodedPassword(t *testing.T) {
+	for _, password := range []string{"p@ss:w/ord", "100%sure", "a b?c#d", "ümlaut"} {
+		srv := startFakeRedis(t, nil)
+		redisURL := (&url.URL{Scheme: "redis", User: url.UserPassword("", password), Host: srv.addr}).String()
+		if strings.Contains(redisURL, password) {
+			t.Fatalf("Expected %q to need encoding in %v", password, redisURL)
+		}
+		closeClientOnCleanup(t, redisURL)
+		rc, err := GetClient(&Options{RedisURL: redisURL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rc.Ping().Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !srv.received("AUTH", password) {
+			t.Errorf("Expected to authenticate with %q, got %v", password, srv.recorded())
+		}
+	}
+}
+
+func TestCachedURLs(t *testing.T) {
+	first, second := startFakeRedis(t, nil), startFakeRedis(t, nil)
+	closeClientOnCleanup(t, first.url())
+	closeClientOnCleanup(t, "redis://"+second.addr+"/3")
+
+	if _, err := GetClient(&Options{RedisURL: first.url()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetClient(&Options{RedisURL: "redis://:secret@" + second.addr + "/3"}); err != nil {
+		t.Fatal(err)
+	}
+	// A second client for the same URL isn't listed twice
+	if _, err := GetPubSubClient(&Options{RedisURL: first.url()}); err != nil {
+		t.Fatal(err)
+	}
+
+	found := make(map[string]int)
+	for _, cached := range CachedURLs() {
+		found[cached]++
+		if strings.Contains(cached, "secret") {
+			t.Errorf("Expected no credentials in %v", cached)
+		}
+	}
+	for _, expected := range []string{first.url() + "/0", "redis://" + second.addr + "/3"} {
+		if found[expected] != 1 {
+			t.Errorf("Expected %v to be listed once, got %v", expected, CachedURLs())
+		}
+	}
+}
+
+func TestRunWithContext(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	rc, err := GetClient(&Options{RedisURL: srv.url()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unblock := make(chan struct{})
+	defer close(unblock)
+	srv.setReply(func(args []string) string {
+		if args[0] == "BLPOP" {
+			<-unblock
+			return "*-1\r\n"
+		}
+		return ""
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = RunWithContext(ctx, rc, func(rc *redis.Client) error {
+		return rc.BLPop(0, "queue").Err()
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected to give up once the deadline passed, took %v", elapsed)
+	}
+
+	err = RunWithContext(context.Background(), rc, func(rc *redis.Client) error {
+		return rc.Set("key", "value", 0).Err()
+	})
+	if err != nil {
+		t.Errorf("Expected the result of fn when ctx isn't done: %v", err)
+	}
+}
+
+func TestExplicitDB(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url()+"/1")
+	closeClientOnCleanup(t, srv.url()+"/3")
+
+	db := 3
+	rc, err := GetClient(&Options{RedisURL: srv.url() + "/1", ExplicitDB: &db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !srv.received("SELECT", "3") || srv.received("SELECT", "1") {
+		t.Errorf("Expected DB 3 to be selected instead of the one in the path, got %v", srv.recorded())
+	}
+
+	fromPath, err := GetClient(&Options{RedisURL: srv.url() + "/1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromPath == rc {
+		t.Error("Expected separate clients for the URL path and ExplicitDB")
+	}
+	if again, _ := GetClient(&Options{RedisURL: srv.url() + "/3"}); again != rc {
+		t.Error("Expected ExplicitDB to share the client for its database")
+	}
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	opts := &Options{RedisURL: srv.url(), HealthCheckTimeout: 100 * time.Millisecond}
+	if err := Ping(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server keeps the connection open but never replies
+	unblock := make(chan struct{})
+	defer close(unblock)
+	srv.setReply(func(args []string) string {
+		<-unblock
+		return ""
+	})
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		if err := Ping(context.Background(), opts); err != context.DeadlineExceeded {
+			t.Errorf("Expected a hanging server to be reported as unhealthy, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected Ping to give up after HealthCheckTimeout, took %v", elapsed)
+		}
+	}
+}
+
+func TestGetCmdable(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	opts := &Options{RedisURL: srv.url()}
+	cmdable, err := GetCmdable(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdable.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if rc, _ := GetClient(opts); cmdable != redis.Cmdable(rc) {
+		t.Error("Expected GetCmdable to return the cached client")
+	}
+
+	cmdable, err = GetCmdable(&Options{RedisURL: "ftp://localhost"})
+	if err == nil || cmdable != nil {
+		t.Errorf("Expected an error and a nil redis.Cmdable, got %v (%v)", cmdable, err)
+	}
+}
+
+func TestSkipAuth(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	redisURL := "redis://user:s3cret@" + srv.addr + "/2"
+	closeClientOnCleanup(t, redisURL)
+	opts := &Options{
+		RedisURL:         redisURL,
+		SkipAuth:         true,
+		PasswordEnv:      "TLSREDIS_TEST_UNSET_PASSWORD",
+		PasswordProvider: func() (string, error) { return "provided", nil },
+	}
+	opts.Password = "static"
+	rc, err := GetClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+	if password := clientStringOption(rc, "Password"); password != "" {
+		t.Errorf("Expected the client to have no password, got %q", password)
+	}
+	for _, name := range srv.recordedNames() {
+		if name == "AUTH" {
+			t.Errorf("Expected no AUTH under SkipAuth, got %v", srv.recorded())
+		}
+	}
+	if !srv.received("SELECT", "2") {
+		t.Error("Expected the database to be selected regardless")
+	}
+}
+
+func TestNilOptions(t *testing.T) {
+	ctx := context.Background()
+	for name, fn := range map[string]func() error{
+		"GetClient":              func() error { _, err := GetClient(nil); return err },
+		"GetCmdable":             func() error { _, err := GetCmdable(nil); return err },
+		"GetClientForDB":         func() error { _, err := GetClientForDB("redis://localhost", 1, nil); return err },
+		"GetClientContext":       func() error { _, err := GetClientContext(ctx, nil); return err },
+		"GetContextScopedClient": func() error { _, err := GetContextScopedClient(ctx, nil); return err },
+		"GetClientInfo":          func() error { _, _, err := GetClientInfo(nil); return err },
+		"GetManagedClient":       func() error { _, err := GetManagedClient(nil); return err },
+		"GetPubSubClient":        func() error { _, err := GetPubSubClient(nil); return err },
+		"GetReplicaClient": func() error {
+			_, err := GetReplicaClient("redis://localhost:1", "redis://localhost:2", nil)
+			return err
+		},
+		"GetDBSelector":       func() error { _, err := GetDBSelector(nil); return err },
+		"BuildDialer":         func() error { _, err := BuildDialer(nil); return err },
+		"TestConnection":      func() error { return TestConnection(ctx, nil) },
+		"Ping":                func() error { return Ping(ctx, nil) },
+		"Reload":              func() error { _, err := Reload("redis://localhost", nil); return err },
+		"ValidateCredentials": func() error { _, err := ValidateCredentials(nil); return err },
+		"TLSConfig":           func() error { _, err := NewConfigBuilder().TLSConfig(nil); return err },
+	} {
+		if err := fn(); err != ErrNilOptions {
+			t.Errorf("%v: expected ErrNilOptions, got %v", name, err)
+		}
+	}
+}
+
+func TestClock(t *testing.T) {
+	clock := time.Now()
+	now = func() time.Time { return clock }
+	lookups := 0
+	origLookupAddr := lookupAddr
+	lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		return []string{"redis.internal"}, nil
+	}
+	t.Cleanup(func() {
+		now = time.Now
+		lookupAddr = origLookupAddr
+		reverseDNSCacheMutex.Lock()
+		reverseDNSCache = make(map[string]reverseDNSEntry)
+		reverseDNSCacheMutex.Unlock()
+	})
+
+	// The reverse DNS cache expires according to the clock
+	for _, advance := range []time.Duration{0, reverseDNSCacheTTL - time.Second, 2 * time.Second} {
+		clock = clock.Add(advance)
+		if _, err := reverseLookup(context.Background(), "192.0.2.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lookups != 2 {
+		t.Errorf("Expected the cached names to be looked up again once the TTL passed, got %d lookups", lookups)
+	}
+
+	// As do certificates
+	ca := newTestCA(t, "Test CA")
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM())
+	if _, err := ValidateCredentials(&Options{RedisCAFile: caFile}); err != nil {
+		t.Fatal(err)
+	}
+	clock = ca.cert.NotAfter.Add(time.Second)
+	if _, err := ValidateCredentials(&Options{RedisCAFile: caFile}); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Expected the CA to have expired according to the clock, got %v", err)
+	}
+}
+
+func TestGetContextScopedClient(t *testing.T) {
+	srv := startFakeRedis(t, nil)
+	closeClientOnCleanup(t, srv.url())
+	cached, err := GetClient(&Options{RedisURL: srv.url()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rc, err := GetContextScopedClient(ctx, &Options{RedisURL: srv.url()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc == cached {
+		t.Fatal("Expected the scoped client not to come from the cache")
+	}
+	if err := rc.Ping().Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	for deadline := time.Now().Add(2 * time.Second); rc.Ping().Err() == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the client to be closed once the context was cancelled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := rc.Ping().Err(); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("Expected the client to be closed, got %v", err)
+	}
+	if err := cached.Ping().Err(); err != nil {
+		t.Errorf("Expected the cached client to be unaffected: %v", err)
+	}
+	if again, _ := GetClient(&Options{RedisURL: srv.url()}); again != cached {
+		t.Error("Expected the cached client to stay cached")
+	}
+}
+
+func TestPoolSize(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		getClient func(*Options) (*redis.Client, error)
+		poolSize  int
+		expected  int64
+	}{
+		{"GetClient", GetClient, 0, 3},
+		{"GetClient", GetClient, 7, 7},
+		{"GetPubSubClient", GetPubSubClient, 0, 2},
+		{"GetPubSubClient", GetPubSubClient, 5, 5},
+	} {
+		srv := startFakeRedis(t, nil)
+		closeClientOnCleanup(t, srv.url())
+		opts := &Options{RedisURL: srv.url()}
+		opts.PoolSize = tc.poolSize
+		rc, err := tc.getClient(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if poolSize := clientOption(rc, "PoolSize"); poolSize != tc.expected {
+			t.Errorf("%v with PoolSize %d: expected a pool of %d, got %d", tc.name, tc.poolSize, tc.expected, poolSize)
+		}
+	}
+}
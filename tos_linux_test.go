@@ -0,0 +1,66 @@
+//go:build linux
+
+package tlsredis
+
+import (
+	"crypto/tls"
+	"net"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketTOS returns the IP_TOS socket option of the TCP connection under conn.
+func socketTOS(t *testing.T, conn net.Conn) int {
+	t.Helper()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		t.Fatalf("Unable to get at the socket of %T", conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tos int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		tos, sockErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if sockErr != nil {
+		t.Fatal(sockErr)
+	}
+	return tos
+}
+
+func TestTOS(t *testing.T) {
+	plaintext := startFakeRedis(t, nil)
+	secure, caFile := startTLSFakeRedis(t)
+	for _, tc := range []struct {
+		opts     *Options
+		expected int
+	}{
+		{&Options{RedisURL: plaintext.url()}, 0},
+		{&Options{RedisURL: plaintext.url(), TOS: 0xb8}, 0xb8},
+		{&Options{RedisURL: secure.url(), RedisCAFile: caFile, TOS: 0x28}, 0x28},
+	} {
+		dial, err := BuildDialer(tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tos := socketTOS(t, conn); tos != tc.expected {
+			t.Errorf("%v: expected TOS %#x, got %#x", tc.opts.RedisURL, tc.expected, tos)
+		}
+		pingConn(t, conn)
+		conn.Close()
+	}
+}
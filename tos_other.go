@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package tlsredis
+
+import (
+	"net"
+	"runtime"
+)
+
+// setTOS is a no-op on platforms where we don't know how to set the IP type of
+// service.
+func setTOS(conn net.Conn, tos int) error {
+	log.Debugf("Setting TOS isn't supported on %v, ignoring", runtime.GOOS)
+	return nil
+}
@@ -0,0 +1,40 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package tlsredis
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setTOS sets the IP type of service (or IPv6 traffic class) of conn to tos.
+// Connections that don't expose their socket, like ones from a custom DialFunc,
+// are left alone.
+func setTOS(conn net.Conn, tos int) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		log.Debugf("Unable to set TOS on %T, it doesn't expose its socket", conn)
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	level, opt := unix.IPPROTO_IP, unix.IP_TOS
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && addr.IP.To4() == nil {
+		level, opt = unix.IPPROTO_IPV6, unix.IPV6_TCLASS
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), level, opt, tos)
+	}); err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("Unable to set TOS to %d: %v", tos, sockErr)
+	}
+	return nil
+}
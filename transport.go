@@ -0,0 +1,18 @@
+package tlsredis
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// Transport establishes secure connections to Redis, as an alternative to TLS
+// over TCP. See Options.Transport.
+type Transport interface {
+	// Dial connects to addr (host:port) on network (tcp, tcp4 or tcp6) and
+	// secures the connection according to tlsConfig, which must not be
+	// modified. It should give up once ctx is done, which happens after
+	// DialTimeout. If the returned connection has a ConnectionState method
+	// like tls.Conn, its state is reported by LastConnectionState.
+	Dial(ctx context.Context, network string, addr string, tlsConfig *tls.Config) (net.Conn, error)
+}
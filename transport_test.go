@@ -0,0 +1,77 @@
+package tlsredis
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// stubTransport is a Transport that does TLS over TCP itself, recording what
+// it was asked to dial.
+type stubTransport struct {
+	network, addr string
+	tlsConfig     *tls.Config
+	err           error
+}
+
+func (transport *stubTransport) Dial(ctx context.Context, network string, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	transport.network, transport.addr, transport.tlsConfig = network, addr, tlsConfig
+	if transport.err != nil {
+		return nil, transport.err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func TestTransport(t *testing.T) {
+	srv, caFile := startTLSFakeRedis(t)
+	transport := &stubTransport{}
+	var hooked *tls.Config
+	dial, err := BuildDialer(&Options{
+		RedisURL:    srv.url(),
+		RedisCAFile: caFile,
+		Transport:   transport,
+		TLSConfigHook: func(tlsConfig *tls.Config) {
+			hooked = tlsConfig
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pingConn(t, conn)
+	conn.Close()
+	if transport.network != "tcp" || transport.addr != srv.addr {
+		t.Errorf("Expected the transport to dial tcp %v, got %v %v", srv.addr, transport.network, transport.addr)
+	}
+	if transport.tlsConfig == nil || transport.tlsConfig != hooked || transport.tlsConfig.RootCAs == nil || transport.tlsConfig.ServerName != "127.0.0.1" {
+		t.Errorf("Expected the transport to get the TLS configuration built from the options, got %+v", transport.tlsConfig)
+	}
+	if _, found := LastConnectionState(srv.url()); !found {
+		t.Error("Expected the connection state from the transport to be recorded")
+	}
+
+	transport.err = errors.New("no QUIC for you")
+	if _, err := dial(); err == nil || !strings.Contains(err.Error(), "(transport)") || !strings.Contains(err.Error(), "no QUIC for you") {
+		t.Errorf("Expected the transport's error, got %v", err)
+	}
+
+	if _, err := BuildDialer(&Options{RedisURL: srv.url(), Transport: transport, MaxConnsPerHost: 1}); err == nil {
+		t.Error("Expected Transport and MaxConnsPerHost to be rejected together")
+	}
+}
@@ -0,0 +1,207 @@
+package tlsredis
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+)
+
+// addVerifier adds verify to the checks that tlsConfig performs on every
+// connection, after any checks that are already installed.
+func addVerifier(tlsConfig *tls.Config, verify func(tls.ConnectionState) error) {
+	prev := tlsConfig.VerifyConnection
+	if prev == nil {
+		tlsConfig.VerifyConnection = verify
+		return
+	}
+	tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		if err := prev(cs); err != nil {
+			return err
+		}
+		return verify(cs)
+	}
+}
+
+// verifyServerNames replaces the standard host name verification of tlsConfig
+// with one that accepts a server certificate that's valid for any of names.
+// The certificate chain is still verified against tlsConfig.RootCAs unless
+// tlsConfig skips verification altogether.
+func verifyServerNames(tlsConfig *tls.Config, names []string) {
+	roots := tlsConfig.RootCAs
+	verifyChain := !tlsConfig.InsecureSkipVerify
+	tlsConfig.InsecureSkipVerify = true
+	addVerifier(tlsConfig, func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("Server presented no certificate")
+		}
+		leaf := cs.PeerCertificates[0]
+		if verifyChain {
+			if err := verifyPeerChain(cs, roots); err != nil {
+				return err
+			}
+		}
+		for _, name := range names {
+			if matchesServerName(leaf, name) {
+				return nil
+			}
+		}
+		return fmt.Errorf("Server certificate is not valid for any of %v", names)
+	})
+}
+
+// peerIPConfig returns a copy of tlsConfig that verifies the server certificate
+// against the IP address that conn is connected to.
+func peerIPConfig(tlsConfig *tls.Config, conn net.Conn) (*tls.Config, error) {
+	host, err := remoteHost(conn)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("Unable to verify server by IP, %v is not an IP address", host)
+	}
+	tlsConfig = tlsConfig.Clone()
+	verifyServerNames(tlsConfig, []string{host})
+	return tlsConfig, nil
+}
+
+// remoteHost returns the host conn is connected to, without the zone of a
+// link-local IPv6 address since certificates and PTR records can't name it.
+func remoteHost(conn net.Conn) (string, error) {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return "", fmt.Errorf("Unable to determine IP of %v: %v", conn.RemoteAddr(), err)
+	}
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	return host, nil
+}
+
+// verifyServerAuthEKU checks that the server's leaf certificate is explicitly
+// meant for server authentication.
+func verifyServerAuthEKU(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("Server presented no certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+	hasServerAuth := false
+	for _, eku := range leaf.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageServerAuth {
+			hasServerAuth = true
+		}
+	}
+	if !hasServerAuth {
+		return fmt.Errorf("Server certificate for %v lacks the serverAuth extended key usage", leaf.Subject)
+	}
+	if leaf.KeyUsage != 0 && leaf.KeyUsage&(x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment) == 0 {
+		return fmt.Errorf("Server certificate for %v has a key usage that doesn't allow TLS", leaf.Subject)
+	}
+	return nil
+}
+
+// pinnedLeafVerifier returns a check that the server's leaf certificate has one
+// of serials (in hex) and one of subjects, ignoring whichever of them is empty.
+func pinnedLeafVerifier(serials []string, subjects []string) (func(tls.ConnectionState) error, error) {
+	var pinnedSerials []*big.Int
+	for _, serial := range serials {
+		n, ok := new(big.Int).SetString(strings.Replace(serial, ":", "", -1), 16)
+		if !ok {
+			return nil, fmt.Errorf("Invalid pinned serial %q, expected hex", serial)
+		}
+		pinnedSerials = append(pinnedSerials, n)
+	}
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("Server presented no certificate")
+		}
+		leaf := cs.PeerCertificates[0]
+		if len(pinnedSerials) > 0 {
+			matched := false
+			for _, serial := range pinnedSerials {
+				if serial.Cmp(leaf.SerialNumber) == 0 {
+					matched = true
+				}
+			}
+			if !matched {
+				return fmt.Errorf("Server certificate serial %X is not pinned", leaf.SerialNumber)
+			}
+		}
+		if len(subjects) > 0 {
+			subject := leaf.Subject.String()
+			matched := false
+			for _, pinned := range subjects {
+				if pinned == subject {
+					matched = true
+				}
+			}
+			if !matched {
+				return fmt.Errorf("Server certificate subject %v is not pinned", subject)
+			}
+		}
+		return nil
+	}, nil
+}
+
+// signatureAlgorithmVerifier returns a check that the certificates the server
+// presented are signed using one of allowed, except for self-signed ones.
+func signatureAlgorithmVerifier(allowed []x509.SignatureAlgorithm) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		for _, cert := range cs.PeerCertificates {
+			if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil {
+				continue
+			}
+			accepted := false
+			for _, algorithm := range allowed {
+				if algorithm == cert.SignatureAlgorithm {
+					accepted = true
+				}
+			}
+			if !accepted {
+				return fmt.Errorf("Server certificate for %v is signed with disallowed algorithm %v", cert.Subject, cert.SignatureAlgorithm)
+			}
+		}
+		return nil
+	}
+}
+
+// verifyPeerChain verifies the peer's certificate chain against roots (or the
+// system roots if roots is nil) without checking the host name.
+func verifyPeerChain(cs tls.ConnectionState, roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now(),
+	})
+	return err
+}
+
+// matchesServerName reports whether cert is valid for name. In addition to the
+// usual matching (including wildcard certificates), name may itself be a
+// wildcard like *.example.com, in which case it matches any DNS name in the
+// certificate that has exactly one label in place of the *.
+func matchesServerName(cert *x509.Certificate, name string) bool {
+	if cert.VerifyHostname(name) == nil {
+		return true
+	}
+	if !strings.HasPrefix(name, "*.") {
+		return false
+	}
+	suffix := strings.ToLower(name[1:])
+	for _, dnsName := range cert.DNSNames {
+		dnsName = strings.ToLower(dnsName)
+		label := strings.TrimSuffix(dnsName, suffix)
+		if dnsName == strings.ToLower(name) || (label != dnsName && label != "" && !strings.Contains(label, ".")) {
+			return true
+		}
+	}
+	return false
+}
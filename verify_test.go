@@ -0,0 +1,268 @@
+package tlsredis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestAllowedServerNames(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	cert := issueCert(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "redis"},
+		DNSNames:    []string{"*.redis.example.com"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	srv := startFakeRedis(t, serverTLSConfig(cert))
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM())
+	otherCAFile := writeTestFile(t, "other-ca.pem", newTestCA(t, "Other CA").certPEM())
+
+	for _, tc := range []struct {
+		names  []string
+		caFile string
+		ok     bool
+	}{
+		{nil, caFile, false},
+		{[]string{"other.example.com"}, caFile, false},
+		{[]string{"other.example.com", "cache.redis.example.com"}, caFile, true},
+		{[]string{"*.redis.example.com"}, caFile, true},
+		{[]string{"cache.redis.example.com"}, otherCAFile, false},
+	} {
+		dial, err := BuildDialer(&Options{RedisURL: srv.url(), RedisCAFile: tc.caFile, AllowedServerNames: tc.names})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dial()
+		if tc.ok && err != nil {
+			t.Errorf("%v: expected to connect, got %v", tc.names, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("%v with %v: expected verification to fail", tc.names, tc.caFile)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+func TestRequireServerAuthEKU(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	// Without any EKU, x509 treats the certificate as valid for any purpose
+	cert := issueCert(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "redis"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+	})
+	srv := startFakeRedis(t, serverTLSConfig(cert))
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM())
+	properSrv := startFakeRedis(t, serverTLSConfig(newServerCert(t, ca)))
+
+	for _, tc := range []struct {
+		srv     *fakeRedis
+		require bool
+		ok      bool
+	}{
+		{srv, false, true},
+		{srv, true, false},
+		{properSrv, true, true},
+	} {
+		dial, err := BuildDialer(&Options{RedisURL: tc.srv.url(), RedisCAFile: caFile, RequireServerAuthEKU: tc.require})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dial()
+		if tc.ok && err != nil {
+			t.Errorf("RequireServerAuthEKU %v: expected to connect, got %v", tc.require, err)
+		} else if !tc.ok && (err == nil || !strings.Contains(err.Error(), "serverAuth")) {
+			t.Errorf("RequireServerAuthEKU %v: expected the certificate without the EKU to be rejected, got %v", tc.require, err)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+func TestVerifyServerName(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	cert := issueCert(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "redis"},
+		DNSNames:    []string{"redis.internal"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}).tlsCertificate()
+	// Like a proxy routing on SNI, the server only answers for localhost
+	srv := startFakeRedis(t, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "localhost" {
+				return nil, fmt.Errorf("Unknown server name %q", hello.ServerName)
+			}
+			return &cert, nil
+		},
+	})
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM())
+	_, port, _ := net.SplitHostPort(srv.addr)
+	redisURL := "rediss://localhost:" + port
+
+	for _, tc := range []struct {
+		redisURL         string
+		verifyServerName string
+		ok               bool
+	}{
+		{redisURL, "redis.internal", true},
+		{redisURL, "", false},
+		{redisURL, "other.internal", false},
+		// The SNI doesn't match any more
+		{srv.url(), "redis.internal", false},
+	} {
+		dial, err := BuildDialer(&Options{RedisURL: tc.redisURL, RedisCAFile: caFile, VerifyServerName: tc.verifyServerName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dial()
+		if tc.ok && err != nil {
+			t.Errorf("%v verified as %q: expected to connect, got %v", tc.redisURL, tc.verifyServerName, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("%v verified as %q: expected to fail", tc.redisURL, tc.verifyServerName)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+
+	if _, err := BuildDialer(&Options{RedisURL: redisURL, VerifyServerName: "redis.internal", AllowedServerNames: []string{"redis.internal"}}); err == nil {
+		t.Error("Expected VerifyServerName to be rejected with AllowedServerNames")
+	}
+}
+
+func TestPinnedSerialsAndSubjects(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	cert := issueCert(t, ca, &x509.Certificate{
+		SerialNumber: big.NewInt(0x0a1b2c),
+		Subject:      pkix.Name{CommonName: "redis", Organization: []string{"Example"}},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	srv := startFakeRedis(t, serverTLSConfig(cert))
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM())
+
+	for _, tc := range []struct {
+		serials  []string
+		subjects []string
+		expected string
+	}{
+		{[]string{"0A1B2C"}, nil, ""},
+		{[]string{"ff", "0a:1b:2c"}, nil, ""},
+		{nil, []string{"CN=redis,O=Example"}, ""},
+		{[]string{"A1B2C"}, []string{"CN=other", "CN=redis,O=Example"}, ""},
+		{[]string{"0A1B2D"}, nil, "Server certificate serial A1B2C is not pinned"},
+		{nil, []string{"CN=redis"}, "Server certificate subject CN=redis,O=Example is not pinned"},
+		{[]string{"0A1B2C"}, []string{"CN=other"}, "is not pinned"},
+	} {
+		dial, err := BuildDialer(&Options{RedisURL: srv.url(), RedisCAFile: caFile, PinnedSerials: tc.serials, PinnedSubjects: tc.subjects})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dial()
+		if tc.expected == "" && err != nil {
+			t.Errorf("%v %v: expected to connect, got %v", tc.serials, tc.subjects, err)
+		} else if tc.expected != "" && (err == nil || !strings.Contains(err.Error(), tc.expected)) {
+			t.Errorf("%v %v: expected an error containing %q, got %v", tc.serials, tc.subjects, tc.expected, err)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+
+	if _, err := BuildDialer(&Options{RedisURL: srv.url(), PinnedSerials: []string{"not hex"}}); err == nil || !strings.Contains(err.Error(), "Invalid pinned serial") {
+		t.Errorf("Expected an error for a malformed serial, got %v", err)
+	}
+}
+
+func TestAllowedSignatureAlgorithms(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM())
+	serverSignedWith := func(algorithm x509.SignatureAlgorithm) *fakeRedis {
+		return startFakeRedis(t, serverTLSConfig(issueCert(t, ca, &x509.Certificate{
+			Subject:            pkix.Name{CommonName: "redis"},
+			IPAddresses:        []net.IP{net.ParseIP("127.0.0.1")},
+			ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			SignatureAlgorithm: algorithm,
+		})))
+	}
+	allowed := []x509.SignatureAlgorithm{x509.ECDSAWithSHA256, x509.SHA256WithRSA}
+
+	for _, tc := range []struct {
+		algorithm x509.SignatureAlgorithm
+		allowed   []x509.SignatureAlgorithm
+		expected  string
+	}{
+		{x509.ECDSAWithSHA256, allowed, ""},
+		{x509.ECDSAWithSHA384, nil, ""},
+		// Chains up fine, but isn't in the list
+		{x509.ECDSAWithSHA384, allowed, "signed with disallowed algorithm ECDSA-SHA384"},
+		{x509.ECDSAWithSHA256, []x509.SignatureAlgorithm{x509.SHA256WithRSA}, "signed with disallowed algorithm ECDSA-SHA256"},
+	} {
+		srv := serverSignedWith(tc.algorithm)
+		dial, err := BuildDialer(&Options{RedisURL: srv.url(), RedisCAFile: caFile, AllowedSignatureAlgorithms: tc.allowed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dial()
+		if tc.expected == "" && err != nil {
+			t.Errorf("%v allowing %v: expected to connect, got %v", tc.algorithm, tc.allowed, err)
+		} else if tc.expected != "" && (err == nil || !strings.Contains(err.Error(), tc.expected)) {
+			t.Errorf("%v allowing %v: expected an error containing %q, got %v", tc.algorithm, tc.allowed, tc.expected, err)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+func TestSANType(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	caFile := writeTestFile(t, "ca.pem", ca.certPEM())
+	// Only an IP SAN, no DNS SANs
+	srv := startFakeRedis(t, serverTLSConfig(issueCert(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "redis"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})))
+	_, port, _ := net.SplitHostPort(srv.addr)
+	byName := "rediss://localhost:" + port
+
+	for _, tc := range []struct {
+		opts    *Options
+		success bool
+	}{
+		{&Options{RedisURL: srv.url()}, true},
+		{&Options{RedisURL: byName}, false},
+		{&Options{RedisURL: byName, VerifyPeerIP: true}, true},
+		{&Options{RedisURL: byName, VerifyServerName: "127.0.0.1"}, true},
+		{&Options{RedisURL: byName, VerifyServerName: "127.0.0.2"}, false},
+	} {
+		tc.opts.RedisCAFile = caFile
+		dial, err := BuildDialer(tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dial()
+		if tc.success && err != nil {
+			t.Errorf("%v with VerifyPeerIP %v and VerifyServerName %q: expected to connect, got %v", tc.opts.RedisURL, tc.opts.VerifyPeerIP, tc.opts.VerifyServerName, err)
+		} else if !tc.success && err == nil {
+			t.Errorf("%v with VerifyPeerIP %v and VerifyServerName %q: expected verification to fail", tc.opts.RedisURL, tc.opts.VerifyPeerIP, tc.opts.VerifyServerName)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+
+	if _, err := BuildDialer(&Options{RedisURL: byName, RedisCAFile: caFile, VerifyPeerIP: true, VerifyServerName: "redis"}); err == nil {
+		t.Error("Expected VerifyPeerIP not to combine with VerifyServerName")
+	}
+}
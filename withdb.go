@@ -0,0 +1,86 @@
+package tlsredis
+
+import (
+	"fmt"
+
+	"gopkg.in/redis.v5"
+)
+
+// DBSelector gives access to all databases on a Redis server through the pool
+// of a single client, selecting the database for each command separately.
+type DBSelector struct {
+	client *redis.Client
+	db     int
+}
+
+// GetDBSelector gets the client for opts like GetClient does and wraps it in a
+// DBSelector. The database in RedisURL is the one that the client uses by
+// default.
+func GetDBSelector(opts *Options) (*DBSelector, error) {
+	if opts == nil {
+		return nil, ErrNilOptions
+	}
+	u, err := parseURL(opts.RedisURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	db := opts.db(u)
+	rc, err := getClient(opts, u, db)
+	if err != nil {
+		return nil, err
+	}
+	return &DBSelector{client: rc, db: db}, nil
+}
+
+// Client returns the underlying client, which uses the default database.
+func (s *DBSelector) Client() *redis.Client {
+	return s.client
+}
+
+// WithDB returns a client that runs each command against database db, after
+// checking that db can be selected. SELECT is scoped to the connection, so
+// every command is wrapped in a MULTI/EXEC transaction that selects db, runs
+// the command and then selects the default database again, all on the same
+// connection. That makes each command somewhat more expensive, and blocking
+// commands like BLPOP don't block inside a transaction.
+//
+// If selecting db fails, the command still runs against whichever database
+// the connection was using. The error is then returned by the client's
+// Process, though the command's own Err may be nil.
+//
+// Only individual commands go through this: pipelines, transactions and
+// subscriptions made with the returned client use the default database. The
+// returned client shares its pool with the underlying client, so it must not
+// be closed.
+func (s *DBSelector) WithDB(db int) (*redis.Client, error) {
+	if db == s.db {
+		return s.client, nil
+	}
+	defaultDB := s.db
+	if _, err := s.client.TxPipelined(func(pipe *redis.Pipeline) error {
+		pipe.Select(db)
+		pipe.Select(defaultDB)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("Unable to select database %d: %v", db, err)
+	}
+
+	rc := s.client.WithContext(s.client.Context())
+	rc.WrapProcess(func(func(redis.Cmder) error) func(redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			_, err := s.client.TxPipelined(func(pipe *redis.Pipeline) error {
+				pipe.Select(db)
+				pipe.Process(cmd)
+				pipe.Select(defaultDB)
+				return nil
+			})
+			// The pipeline sets the outcome on cmd itself, but cmd may have
+			// succeeded even though selecting the database failed
+			if cmd.Err() == nil && err != nil {
+				return fmt.Errorf("Unable to select database %d: %v", db, err)
+			}
+			return cmd.Err()
+		}
+	})
+	return rc, nil
+}
@@ -0,0 +1,153 @@
+package tlsredis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"gopkg.in/redis.v5"
+)
+
+// startDBRedis starts a fake Redis that keeps SET values per database and
+// supports SELECT, GET and MULTI/EXEC, returning its address. SELECT is
+// rejected for databases above maxDB.
+func startDBRedis(t *testing.T, maxDB *int32) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	var mx sync.Mutex
+	data := make(map[string]string)
+	serveConn := func(conn net.Conn) {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		db := 0
+		exec := func(args []string) string {
+			mx.Lock()
+			defer mx.Unlock()
+			switch args[0] {
+			case "SELECT":
+				n, err := strconv.Atoi(args[1])
+				if err != nil || n < 0 || n > int(atomic.LoadInt32(maxDB)) {
+					return "-ERR DB index is out of range\r\n"
+				}
+				db = n
+			case "SET":
+				data[fmt.Sprintf("%d/%v", db, args[1])] = args[2]
+			case "GET":
+				value, found := data[fmt.Sprintf("%d/%v", db, args[1])]
+				if !found {
+					return "$-1\r\n"
+				}
+				return bulkString(value)
+			}
+			return "+OK\r\n"
+		}
+		var queued [][]string
+		inMulti := false
+		for {
+			args, err := readCommand(r)
+			if err != nil {
+				return
+			}
+			args[0] = strings.ToUpper(args[0])
+			resp := "+OK\r\n"
+			switch {
+			case args[0] == "MULTI":
+				inMulti, queued = true, nil
+			case args[0] == "EXEC":
+				inMulti = false
+				resp = fmt.Sprintf("*%d\r\n", len(queued))
+				for _, cmd := range queued {
+					resp += exec(cmd)
+				}
+			case inMulti:
+				queued = append(queued, args)
+				resp = "+QUEUED\r\n"
+			default:
+				resp = exec(args)
+			}
+			if _, err := io.WriteString(conn, resp); err != nil {
+				return
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestDBSelector(t *testing.T) {
+	maxDB := int32(15)
+	redisURL := "redis://" + startDBRedis(t, &maxDB)
+	closeClientOnCleanup(t, redisURL)
+	s, err := GetDBSelector(&Options{RedisURL: redisURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := s.WithDB(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.Set("key", "two", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := s.Client().Get("key").Result(); err == nil {
+		t.Errorf("Expected nothing in database 0, got %q", value)
+	}
+	if value, err := rc.Get("key").Result(); err != nil || value != "two" {
+		t.Errorf("Expected to read back the value from database 2, got %q (%v)", value, err)
+	}
+
+	if err := s.Client().Set("key", "zero", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := s.Client().Get("key").Result(); err != nil || value != "zero" {
+		t.Errorf("Expected the default client to use database 0, got %q (%v)", value, err)
+	}
+	if value, _ := rc.Get("key").Result(); value != "two" {
+		t.Errorf("Expected database 2 to keep its value, got %q", value)
+	}
+
+	if same, err := s.WithDB(0); err != nil || same != s.Client() {
+		t.Errorf("Expected the default database to give the underlying client, got %v (%v)", same, err)
+	}
+	if _, err := s.WithDB(99); err == nil || !strings.Contains(err.Error(), "Unable to select database 99") {
+		t.Errorf("Expected an error for an invalid database, got %v", err)
+	}
+}
+
+func TestDBSelectorSelectFails(t *testing.T) {
+	maxDB := int32(15)
+	redisURL := "redis://" + startDBRedis(t, &maxDB)
+	closeClientOnCleanup(t, redisURL)
+	s, err := GetDBSelector(&Options{RedisURL: redisURL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := s.WithDB(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server stops accepting database 2 after WithDB checked it
+	atomic.StoreInt32(&maxDB, 1)
+	err = rc.Process(redis.NewStatusCmd("SET", "key", "two"))
+	if err == nil || !strings.Contains(err.Error(), "Unable to select database 2") {
+		t.Errorf("Expected the failed SELECT to be reported, got %v", err)
+	}
+}